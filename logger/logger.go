@@ -96,6 +96,23 @@ func SetLogging(logToFile bool, level int) {
 	ERROR = log.New(errorOut, "ERROR ", log.Ldate|log.Ltime)
 }
 
+// StopInterruptHandler stops the logger from closing the log file and exiting the application when it is
+// interrupted. Use it when the application handles interrupts itself, and call Close before it exits.
+func StopInterruptHandler() {
+	signal.Stop(closeChan)
+}
+
+// Close closes the log file, if logging to one. Anything logged after Close is discarded.
+func Close() error {
+	if logFileHandle == nil {
+		return nil
+	}
+	SetLogging(false, NoLogLevel)
+	err := logFileHandle.Close()
+	logFileHandle = nil
+	return err
+}
+
 func gracefulLogFileCloser() {
 	s := <-closeChan
 
@@ -4,30 +4,20 @@ package main
 
 import (
 	"fmt"
-	"strconv"
 
 	"github.com/droppedbars/strava-commute-times/logger"
 	"github.com/droppedbars/strava-commute-times/stravahelpers"
 )
 
-// getClubMembers iterates through the list of club members and prints out the API response
+// getClubMembers iterates through the list of club members and prints out the first member of each page
 func getClubMembers(clubID uint64) {
-	for i := 1; ; i++ {
-		params := map[string]uint64{
-			"page":     uint64(i),
-			"per_page": 200,
-		}
-		path := fmt.Sprintf(stravahelpers.StravaListClubMembersPath, clubID)
-		arrayJSONResponse, err := stravahelpers.StravaAPIGetArray(path, params)
-		if err != nil {
-			logger.ERROR.Println(err)
-		}
-		if len(arrayJSONResponse) == 0 { // empty response, so no more data
-			break
-		}
-		logger.DEBUG.Println("Page: ", i)
-		logger.TRACE.Println("API call response page: "+strconv.Itoa(i)+": ", arrayJSONResponse)
-		fmt.Println("First Club Member response: ", arrayJSONResponse[1])
+	err := stravahelpers.StravaClubMemberPages(clubID, stravahelpers.PageOptions{}, func(members []stravahelpers.ClubAthlete) error {
+		logger.TRACE.Println("API call response page: ", members)
+		fmt.Printf("First Club Member response: %+v\n", members[0])
+		return nil
+	})
+	if err != nil {
+		logger.ERROR.Println(err)
 	}
 }
 
@@ -35,27 +25,49 @@ func getClubMembers(clubID uint64) {
 // the authenticated user, or the API client doesn't have activities:read_all scope and the activity
 // is private, then the API response will be a 404 - Not Found.
 func getActivity(activityID uint64) {
-	params := map[string]uint64{}
-	path := stravahelpers.StravaGetActivityPath + strconv.FormatUint(activityID, 10)
-	arrayJSONResponse, err := stravahelpers.StravaAPIGetJSON(path, params)
-	if err != nil {
+	activity, err := stravahelpers.StravaGetActivity(activityID)
+	if stravahelpers.IsNotFound(err) {
+		fmt.Printf("Activity %d does not exist, or is private and not visible to the authenticated athlete\n", activityID)
+		return
+	} else if err != nil {
 		logger.ERROR.Println(err)
 	}
-	fmt.Println("Activity response: ", arrayJSONResponse)
+	fmt.Printf("Activity response: %+v\n", activity)
 }
 
 // getAthleteStats will return a Strava athlete's stats. If the athlete is not the
 // the authenticated user then the API response will be a 403 - Forbidden.
 func getAthleteStats(athleteID uint64) {
-	params := map[string]uint64{}
-	path := fmt.Sprintf(stravahelpers.StravaGetAtheleteStatsPath, athleteID)
-	arrayJSONResponse, err := stravahelpers.StravaAPIGetJSON(path, params)
-	if err != nil {
+	arrayJSONResponse, err := stravahelpers.StravaGetAthleteStats(athleteID)
+	if stravahelpers.IsForbidden(err) {
+		fmt.Printf("Athlete %d is not the authenticated athlete, so their stats are not available\n", athleteID)
+		return
+	} else if err != nil {
 		logger.ERROR.Println(err)
 	}
 	fmt.Println("AthleteStats Response: ", arrayJSONResponse)
 }
 
+// getActivityStreams will return the time and position streams of a strava activity, keyed by the
+// type of stream rather than returned as an array. Like getActivity, an activity that isn't visible to
+// the authenticated athlete is a 404 - Not Found.
+func getActivityStreams(activityID uint64) {
+	params := stravahelpers.Params{}.
+		List("keys", stravahelpers.StreamTime, stravahelpers.StreamLatLng, stravahelpers.StreamAltitude).
+		Bool("key_by_type", true)
+	path := fmt.Sprintf(stravahelpers.StravaGetActivityStreamsPath, activityID)
+	jsonResponse, err := stravahelpers.StravaAPIGetJSON(path, params)
+	if stravahelpers.IsNotFound(err) {
+		fmt.Printf("Activity %d does not exist, or is private and not visible to the authenticated athlete\n", activityID)
+		return
+	} else if err != nil {
+		logger.ERROR.Println(err)
+	}
+	for key, stream := range jsonResponse {
+		fmt.Printf("Stream %s: %v\n", key, stream)
+	}
+}
+
 // main execution function.
 func main() {
 	logger.SetLogging(false, logger.TraceLevel)
@@ -67,5 +79,6 @@ func main() {
 
 	getClubMembers(465748)
 	getActivity(12345)
+	getActivityStreams(12345)
 	getAthleteStats(541441)
 }
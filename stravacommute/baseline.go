@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+	"github.com/droppedbars/strava-commute-times/stravahelpers"
+)
+
+// baselineFileName is the name of the commute baseline file within a profile. Use
+// ./commute_baseline.json.template as a template.
+const baselineFileName = "commute_baseline.json"
+
+// weeksInYear is the number of whole weeks the work days per week are counted over.
+const weeksInYear = 52
+
+// baselineEntry is the commute from the day From until the From of the next entry. The first entry
+// also applies to everything before it.
+type baselineEntry struct {
+	From            string  // first day of the commute, eg 2021-09-01, empty for the first entry
+	OneWayKm        float64 // distance from home to work
+	WorkDaysPerWeek float64
+	VacationWeeks   float64 // weeks of the year not at work
+	Holidays        float64 // other days not at work, such as public holidays
+
+	from time.Time // From as a date in UTC
+}
+
+// annualKm returns how far the commute is over a year, there and back on every work day.
+func (e baselineEntry) annualKm() float64 {
+	days := e.WorkDaysPerWeek*(weeksInYear-e.VacationWeeks) - e.Holidays
+	if days < 0 {
+		return 0
+	}
+	return 2 * e.OneWayKm * days
+}
+
+// commuteBaseline is the timeline of the athlete's commute, sorted by when each entry applies from,
+// that the commute by bike is measured against.
+type commuteBaseline []baselineEntry
+
+// defaultBaseline is the baseline of a profile without a baseline file: 25km a day, 5 days a week,
+// with 5 weeks of no riding, which is 5875km a year.
+var defaultBaseline = commuteBaseline{{OneWayKm: 12.5, WorkDaysPerWeek: 5, VacationWeeks: 5}}
+
+// parseBaseline reads a baseline file, which is a json array of entries, and checks it.
+func parseBaseline(data []byte) (commuteBaseline, error) {
+	var baseline commuteBaseline
+	err := json.Unmarshal(data, &baseline)
+	if err != nil {
+		return nil, err
+	}
+	if len(baseline) == 0 {
+		return nil, errors.New("there are no entries")
+	}
+	for i := range baseline {
+		e := &baseline[i]
+		if e.From != "" {
+			e.from, err = time.Parse(dateFormat, e.From)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d must have a From date, eg 2021-09-01: %s", i+1, err)
+			}
+		}
+		if e.OneWayKm < 0 || e.WorkDaysPerWeek < 0 || e.WorkDaysPerWeek > 7 || e.VacationWeeks < 0 || e.VacationWeeks > weeksInYear || e.Holidays < 0 {
+			return nil, fmt.Errorf("entry %d must have a OneWayKm of 0 or more, WorkDaysPerWeek from 0 to 7, VacationWeeks from 0 to %d and Holidays of 0 or more", i+1, weeksInYear)
+		}
+	}
+	sort.SliceStable(baseline, func(i, j int) bool { return baseline[i].from.Before(baseline[j].from) })
+	for i := 1; i < len(baseline); i++ {
+		if baseline[i].From == "" || baseline[i].from.Equal(baseline[i-1].from) {
+			return nil, fmt.Errorf("only the first entry can leave out From, and no two entries can have the same From")
+		}
+	}
+	return baseline, nil
+}
+
+// loadBaseline reads the commute baseline of the profile from the input flag baseline, or the
+// profile's baseline file, or returns the defaultBaseline if there isn't one.
+func loadBaseline(profile string) (commuteBaseline, error) {
+	path := *flagBaseline
+	if path == "" {
+		path = filepath.Join(stravahelpers.ProfileDir(profile), baselineFileName)
+	}
+	data, err := ioutil.ReadFile(path)
+	if *flagBaseline == "" && errors.Is(err, os.ErrNotExist) {
+		logger.INFO.Printf("No %s for %s, using the default commute baseline\n", path, profile)
+		return defaultBaseline, nil
+	} else if err != nil {
+		return nil, err
+	}
+	baseline, err := parseBaseline(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the commute baseline %s: %s", path, err)
+	}
+	logger.INFO.Printf("Commute baseline of %s from %s: %+v\n", profile, path, baseline)
+	return baseline, nil
+}
+
+// km returns how far the commute is from start to end, prorating the annual distance of each entry
+// over the days of each year it applies to.
+func (b commuteBaseline) km(start, end time.Time) float64 {
+	loc := start.Location()
+	km := 0.0
+	for i, entry := range b {
+		// the entry applies from its From, or forever for the first, until the From of the next entry
+		from, to := start, end
+		if i > 0 {
+			entryFrom := time.Date(entry.from.Year(), entry.from.Month(), entry.from.Day(), 0, 0, 0, 0, loc)
+			if entryFrom.After(from) {
+				from = entryFrom
+			}
+		}
+		if i+1 < len(b) {
+			next := b[i+1].from
+			if nextFrom := time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, loc); nextFrom.Before(to) {
+				to = nextFrom
+			}
+		}
+		if from.Before(to) {
+			km += entry.annualKm() * yearsBetween(from, to)
+		}
+	}
+	return km
+}
+
+// yearsBetween returns how many years there are from start to end, counting the part of each year by
+// how many of its days, allowing for leap years, are covered.
+func yearsBetween(start, end time.Time) float64 {
+	years := 0.0
+	for yearStart := time.Date(start.Year(), 1, 1, 0, 0, 0, 0, start.Location()); yearStart.Before(end); yearStart = yearStart.AddDate(1, 0, 0) {
+		yearEnd := yearStart.AddDate(1, 0, 0)
+		from, to := yearStart, yearEnd
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		years += to.Sub(from).Hours() / yearEnd.Sub(yearStart).Hours()
+	}
+	return years
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestCommuteBaseline prorates the default baseline over parts of years, and a timeline of commutes
+// over the years they applied to.
+func TestCommuteBaseline(t *testing.T) {
+	near := func(got, expected float64) bool {
+		return math.Abs(got-expected) < 0.001
+	}
+	start, end := getYearRange(2021, nil)
+	if km := defaultBaseline.km(start, end); !near(km, 5875) {
+		t.Errorf("expected a whole year to be 5875 km, got %.3f", km)
+	}
+	// the second half of 2020, a leap year, and the first half of 2021
+	start = time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC)
+	end = time.Date(2021, 7, 2, 0, 0, 0, 0, time.UTC)
+	if expected := 5875*183.0/366 + 5875*182.0/365; !near(defaultBaseline.km(start, end), expected) {
+		t.Errorf("expected %.3f km, got %.3f", expected, defaultBaseline.km(start, end))
+	}
+
+	// moved closer to work in September 2021, then started working from home in 2023
+	baseline, err := parseBaseline([]byte(`[
+		{"From": "2021-09-01", "OneWayKm": 5, "WorkDaysPerWeek": 3, "VacationWeeks": 4, "Holidays": 11},
+		{"OneWayKm": 10, "WorkDaysPerWeek": 5, "VacationWeeks": 5, "Holidays": 10},
+		{"From": "2023-01-01", "OneWayKm": 0}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := 2 * 10 * (5*47 - 10.0)
+	after := 2 * 5 * (3*48 - 11.0)
+	cases := []struct {
+		year     int
+		expected float64
+	}{
+		{2015, before}, // the first entry applies to the years before it too
+		{2021, before*243/365 + after*122/365},
+		{2022, after},
+		{2023, 0},
+	}
+	for _, c := range cases {
+		start, end := getYearRange(c.year, nil)
+		if km := baseline.km(start, end); !near(km, c.expected) {
+			t.Errorf("%d: expected %.3f km, got %.3f", c.year, c.expected, km)
+		}
+	}
+
+	for _, invalid := range []string{
+		`[]`,
+		`[{"From": "September", "OneWayKm": 5}]`,
+		`[{"OneWayKm": 5, "WorkDaysPerWeek": 8}]`,
+		`[{"OneWayKm": 5}, {"OneWayKm": 6}]`,
+		`[{"From": "2021-01-01", "OneWayKm": 5}, {"From": "2021-01-01", "OneWayKm": 6}]`,
+	} {
+		if _, err := parseBaseline([]byte(invalid)); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
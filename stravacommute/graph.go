@@ -7,7 +7,6 @@ import (
 	"image/draw"
 	"image/png"
 	"os"
-	"sort"
 	"time"
 
 	"github.com/droppedbars/strava-commute-times/logger"
@@ -20,9 +19,44 @@ func ticFormat(x float64) string {
 	return output
 }
 
-func graphResults(results map[int]stravaDistances) {
+// graphDistances charts the distances of each period, and if there is a breakdown, the distances of
+// each month or week of all the periods into a second chart, commute-<breakdown>-<date>.png.
+func graphDistances(distances []stravaDistances, kind, breakdown periodKind, name string) {
+	graphResults(distances, kind, name)
+	if breakdown.name == "" {
+		return
+	}
+	var parts []stravaDistances
+	for _, d := range distances {
+		parts = append(parts, d.breakdown...)
+	}
+	breakdownName := breakdown.name
+	if name != "" {
+		breakdownName = name + "-" + breakdownName
+	}
+	graphResults(parts, breakdown, breakdownName)
+}
+
+// periodTicFormat returns a tic format that labels the bars, which are at 1 for the first period, 2
+// for the second and so on, with the periods.
+func periodTicFormat(results []stravaDistances) func(float64) string {
+	return func(x float64) string {
+		i := int(x) - 1
+		if i < 0 || i >= len(results) {
+			return ""
+		}
+		return results[i].label
+	}
+}
+
+// graphResults charts the distances of each period into commute-<date>.png, or
+// commute-<name>-<date>.png if a name is given. The chart gets wider with the number of periods.
+func graphResults(results []stravaDistances, kind periodKind, name string) {
 	// create the file to write to
 	fileName := fmt.Sprintf("%d-%d-%d", time.Now().Year(), time.Now().Month(), time.Now().Day())
+	if name != "" {
+		fileName = name + "-" + fileName
+	}
 	imgFile, err := os.Create("commute-" + fileName + ".png")
 	if err != nil {
 		logger.ERROR.Panic(err)
@@ -30,7 +64,11 @@ func graphResults(results map[int]stravaDistances) {
 	defer imgFile.Close()
 
 	// draw the base image and set its size
-	i := image.NewRGBA(image.Rect(0, 0, 500, 500))             // RGBA image that is a 500x500 rectangle starting at 0,0
+	width := 500
+	if perPeriod := 60 * (len(results) + 2); perPeriod > width {
+		width = perPeriod
+	}
+	i := image.NewRGBA(image.Rect(0, 0, width, 500))           // RGBA image that is a width x 500 rectangle starting at 0,0
 	bg := image.NewUniform(color.RGBA{0xff, 0xff, 0xff, 0xff}) // white background
 	draw.Draw(i, i.Bounds(), bg, image.ZP, draw.Src)
 
@@ -42,46 +80,31 @@ func graphResults(results map[int]stravaDistances) {
 		FillColor: color.NRGBA{0x80, 0xff, 0x80, 0xff},
 		LineStyle: chart.SolidLine, LineWidth: 2}
 
-	var years []float64
+	var positions []float64
 	var commutes []float64
 	var pleasure []float64
-	firstYear := time.Now().Year()
-	lastYear := epoch
-
-	var keys []int
-	for key := range results {
-		keys = append(keys, key)
-	}
-	sort.Ints(keys)
-
-	for _, resultYear := range keys {
-		years = append(years, float64(results[resultYear].year))
-		commutes = append(commutes, results[resultYear].commute)
-		pleasure = append(pleasure, results[resultYear].pleasure)
-		if firstYear > results[resultYear].year {
-			firstYear = results[resultYear].year
-		}
-		if lastYear < results[resultYear].year {
-			lastYear = results[resultYear].year
-		}
+	for index, result := range results {
+		positions = append(positions, float64(index+1))
+		commutes = append(commutes, result.commute)
+		pleasure = append(pleasure, result.pleasure)
 	}
 
 	// create the chart and add data
 	barc := chart.BarChart{Title: "Strava Commutes and Pleasure Rides"}
 	barc.Key.Hide = false
 	barc.Key.Pos = "itl" // means to left
-	barc.XRange.Fixed(float64(firstYear-1), float64(lastYear+1), 1)
-	barc.XRange.Label = "Year"
-	barc.XRange.TicSetting.Format = ticFormat
+	barc.XRange.Fixed(0, float64(len(results)+1), 1)
+	barc.XRange.Label = title(kind.unit())
+	barc.XRange.TicSetting.Format = periodTicFormat(results)
 	barc.YRange.Label = "Distance (km)"
 	barc.YRange.TicSetting.Format = ticFormat
 	barc.ShowVal = 3 // show the value at top of the bar (above bar doesn't work for stacked graphs)
 
-	barc.AddDataPair("Commutes", years, commutes, red)
-	barc.AddDataPair("Pleasure", years, pleasure, green)
+	barc.AddDataPair("Commutes", positions, commutes, red)
+	barc.AddDataPair("Pleasure", positions, pleasure, green)
 
 	// essentially create the image, and then plot it
-	igr := imgg.AddTo(i, 0, 0, 500, 500, color.RGBA{0xff, 0xff, 0xff, 0xff}, nil, nil)
+	igr := imgg.AddTo(i, 0, 0, width, 500, color.RGBA{0xff, 0xff, 0xff, 0xff}, nil, nil)
 	barc.Stacked = true
 
 	barc.Plot(igr)
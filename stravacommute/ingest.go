@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+	"github.com/droppedbars/strava-commute-times/stravahelpers"
+)
+
+// getCommuteClassifier reads the input flags commutePlaces and commuteRadius, and returns the
+// classifier that decides which ingested activities are commutes.
+func getCommuteClassifier() (stravahelpers.CommuteClassifier, error) {
+	classifier := stravahelpers.CommuteClassifier{Radius: *flagCommuteRadius}
+	for _, place := range strings.Split(*flagCommutePlaces, ";") {
+		place = strings.TrimSpace(place)
+		if place == "" {
+			continue
+		}
+		parts := strings.Split(place, ",")
+		if len(parts) != 2 {
+			return classifier, fmt.Errorf("commute place %q must be latitude,longitude", place)
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil {
+			return classifier, fmt.Errorf("commute place %q: %s", place, err)
+		}
+		lng, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return classifier, fmt.Errorf("commute place %q: %s", place, err)
+		}
+		classifier.Places = append(classifier.Places, stravahelpers.LatLng{Lat: lat, Lng: lng})
+	}
+	return classifier, nil
+}
+
+// ingestDirectory parses the GPX, TCX and FIT files in the directory and its subdirectories, and
+// merges them into the activity store. Files are skipped if they haven't changed since they were
+// recorded in seen, which is updated. Files that can't be parsed are logged and skipped, so one bad
+// file doesn't stop the rest. Activities that are the same ride as one already stored from another
+// file are skipped, as for ActivityStore.ImportTracks. It returns the number of activities ingested.
+func ingestDirectory(store *stravahelpers.ActivityStore, dir string, classifier stravahelpers.CommuteClassifier, seen map[string]time.Time) (int, error) {
+	var activities []stravahelpers.SummaryActivity
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !stravahelpers.IsTrackFile(path) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if modified, ok := seen[path]; ok && modified.Equal(info.ModTime()) {
+			return nil
+		}
+		seen[path] = info.ModTime()
+
+		track, err := stravahelpers.ParseTrackFile(path)
+		if err != nil {
+			logger.WARN.Println("Skipping ", path, ": ", err)
+			return nil
+		}
+		activity := track.Activity()
+		if activity.Type == "" {
+			activity.Type = "Ride" // files that don't say are assumed to be rides, which is what is recorded for commutes
+			activity.SportType = activity.Type
+		}
+		activity.Commute = classifier.IsCommute(activity)
+		logger.DEBUG.Printf("Ingested %s: %s %s %.1f km, commute %v\n", path, activity.Type, activity.StartDate, activity.Distance/1000, activity.Commute)
+		activities = append(activities, activity)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(activities) == 0 {
+		return 0, nil
+	}
+
+	skipped := store.ImportTracks(activities...)
+	for _, activity := range skipped {
+		fmt.Printf("Skipped %s, it started at %s like an activity already stored from another file\n", activity.ExternalID, activity.StartDate)
+	}
+	return len(activities) - len(skipped), store.Save()
+}
+
+// watchDirectory ingests new and changed files in the directory every interval, until ctx is done.
+func watchDirectory(ctx context.Context, profile string, store *stravahelpers.ActivityStore, dir string, classifier stravahelpers.CommuteClassifier, interval time.Duration) error {
+	seen := map[string]time.Time{}
+	fmt.Printf("Watching %s for activity files, press Ctrl-C to stop\n", dir)
+	for {
+		ingested, err := ingestDirectory(store, dir, classifier, seen)
+		if err != nil {
+			return err
+		}
+		if ingested > 0 {
+			fmt.Printf("%s Ingested %d activities into %s\n", time.Now().Format("15:04:05"), ingested, profile)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
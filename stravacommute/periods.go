@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/stravahelpers"
+)
+
+// maxZoneOffset is the furthest any timezone is from UTC, so that the activities that started within
+// a local period can be found by when they started in UTC.
+const maxZoneOffset = 14 * time.Hour
+
+// dateFormat is the format of the from and to flags.
+const dateFormat = "2006-01-02"
+
+// periodKinds are the granularities the distances can be reported by.
+var periodKinds = []string{"year", "fiscal", "season", "quarter", "month", "week", "range"}
+
+// period is a span of time that the distances are totalled over, such as a year or a month.
+type period struct {
+	label   string
+	start   time.Time // inclusive
+	end     time.Time // exclusive
+	clipped bool      // cut short by the start or end of the report, so not the whole of the period
+}
+
+// dateRange returns the days the period covers.
+func (p period) dateRange() string {
+	return p.start.Format(dateFormat) + " to " + p.end.AddDate(0, 0, -1).Format(dateFormat)
+}
+
+// periodKind is the granularity the distances are reported by, one of periodKinds. Fiscal years
+// start on the first of fiscalStart.
+type periodKind struct {
+	name        string
+	fiscalStart time.Month
+}
+
+// floor returns the start of the period that t is in.
+func (k periodKind) floor(t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch k.name {
+	case "fiscal":
+		if month < k.fiscalStart {
+			year--
+		}
+		month = k.fiscalStart
+		day = 1
+	case "season":
+		// meteorological seasons, so winter starts in December of the year before
+		month = time.Month(int(month) - int(month)%3)
+		day = 1
+	case "quarter":
+		month -= (month - 1) % 3
+		day = 1
+	case "month":
+		day = 1
+	case "week":
+		// ISO weeks start on Monday
+		day -= (int(t.Weekday()) + 6) % 7
+	default:
+		month = time.January
+		day = 1
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// next returns the start of the period after the one starting at start.
+func (k periodKind) next(start time.Time) time.Time {
+	switch k.name {
+	case "season", "quarter":
+		return start.AddDate(0, 3, 0)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	case "week":
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(1, 0, 0)
+}
+
+// label returns the name of the period starting at start.
+func (k periodKind) label(start time.Time) string {
+	year := start.Year()
+	switch k.name {
+	case "fiscal":
+		if k.fiscalStart == time.January {
+			return fmt.Sprintf("FY%d", year)
+		}
+		return fmt.Sprintf("FY%d/%02d", year, (year+1)%100)
+	case "season":
+		switch start.Month() {
+		case time.March:
+			return fmt.Sprintf("Spring %d", year)
+		case time.June:
+			return fmt.Sprintf("Summer %d", year)
+		case time.September:
+			return fmt.Sprintf("Autumn %d", year)
+		}
+		return fmt.Sprintf("Winter %d/%02d", year, (year+1)%100)
+	case "quarter":
+		return fmt.Sprintf("%d-Q%d", year, (int(start.Month())+2)/3)
+	case "month":
+		return start.Format("2006-01")
+	case "week":
+		isoYear, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", isoYear, week)
+	}
+	return fmt.Sprint(year)
+}
+
+// unit returns what the period is called in the report, eg "estimated end of year distance".
+func (k periodKind) unit() string {
+	switch k.name {
+	case "fiscal":
+		return "fiscal year"
+	case "range":
+		return "period"
+	}
+	return k.name
+}
+
+// split divides the time from start to end into periods. The first and last are clipped to start
+// and end if they don't line up with the start and end of a period. A range is a single period.
+func (k periodKind) split(start, end time.Time) []period {
+	if k.name == "range" {
+		p := period{start: start, end: end}
+		p.label = p.dateRange()
+		return []period{p}
+	}
+	var periods []period
+	for periodStart := k.floor(start); periodStart.Before(end); periodStart = k.next(periodStart) {
+		p := period{label: k.label(periodStart), start: periodStart, end: k.next(periodStart)}
+		if p.start.Before(start) {
+			p.start, p.clipped = start, true
+		}
+		if p.end.After(end) {
+			p.end, p.clipped = end, true
+		}
+		periods = append(periods, p)
+	}
+	return periods
+}
+
+// getYearRange given a year integer will return when the year starts and when the next year starts, at
+// midnight in loc. Without a location, each activity is reported in its own local time, so the times
+// returned are the wall clock times in UTC, to compare with SummaryActivity.LocalStart.
+func getYearRange(year int, loc *time.Location) (time.Time, time.Time) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.Date(year, 1, 1, 0, 0, 0, 0, loc), time.Date(year+1, 1, 1, 0, 0, 0, 0, loc)
+}
+
+// getSyncFrom returns when the activities need to be synced from to report from start, in any
+// timezone.
+func getSyncFrom(start time.Time) time.Time {
+	return start.Add(-maxZoneOffset)
+}
+
+// localActivities returns the stored activities that started between start, inclusive, and end,
+// exclusive, in the local time given by loc, as for getYearRange. Activities that don't say where
+// they were recorded are in the athlete's timezone kept in the store.
+func localActivities(store *stravahelpers.ActivityStore, start, end time.Time, loc *time.Location) []stravahelpers.SummaryActivity {
+	return startedBetween(store.Activities(start.Add(-maxZoneOffset), end.Add(maxZoneOffset)), start, end, loc, store.Location())
+}
+
+// startedBetween returns the activities that started between start, inclusive, and end, exclusive,
+// in the local time given by loc, or athleteLoc for activities that don't say where they were
+// recorded, as for SummaryActivity.LocalStartOr.
+func startedBetween(allActivities []stravahelpers.SummaryActivity, start, end time.Time, loc, athleteLoc *time.Location) []stravahelpers.SummaryActivity {
+	var activities []stravahelpers.SummaryActivity
+	for _, activity := range allActivities {
+		localStart := activity.LocalStartOr(loc, athleteLoc)
+		if !localStart.Before(start) && localStart.Before(end) {
+			activities = append(activities, activity)
+		}
+	}
+	return activities
+}
+
+// getTimezone reads the input flag timezone, and returns its location, or nil to report each
+// activity in its own local time.
+func getTimezone() (*time.Location, error) {
+	if *flagTimezone == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(*flagTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone must be an IANA timezone, eg America/Vancouver: %s", err)
+	}
+	return loc, nil
+}
+
+// nowIn returns the current time in loc, or the wall clock time here in UTC without a location, as
+// for getYearRange.
+func nowIn(loc *time.Location) time.Time {
+	if loc != nil {
+		return time.Now().In(loc)
+	}
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), now.Nanosecond(), time.UTC)
+}
+
+// getPeriodKind reads the input flags period and fiscalStart, and returns the granularity to report by.
+func getPeriodKind() (periodKind, error) {
+	kind := periodKind{name: *flagPeriod, fiscalStart: time.Month(*flagFiscalStart)}
+	if kind.fiscalStart < time.January || kind.fiscalStart > time.December {
+		return kind, fmt.Errorf("fiscalStart must be a month from 1 to 12, not: %d", *flagFiscalStart)
+	}
+	for _, name := range periodKinds {
+		if name == kind.name {
+			return kind, nil
+		}
+	}
+	return kind, fmt.Errorf("period must be one of %s, not: %s", strings.Join(periodKinds, ", "), kind.name)
+}
+
+// getBreakdownKind reads the input flag breakdown, and returns the granularity to break each period
+// down by, which has an empty name if there is no breakdown.
+func getBreakdownKind() (periodKind, error) {
+	switch *flagBreakdown {
+	case "", "month", "week":
+		return periodKind{name: *flagBreakdown}, nil
+	}
+	return periodKind{}, fmt.Errorf("breakdown must be either month or week, not: %s", *flagBreakdown)
+}
+
+// title returns the unit of a period with its first letter in upper case, for headings.
+func title(unit string) string {
+	return strings.ToUpper(unit[:1]) + unit[1:]
+}
+
+// getReportRange reads the input flags from and to, and returns when the report starts and ends, at
+// midnight in loc as for getYearRange. Without from and to, the report covers the years from getYears.
+// Without to, the report runs to the end of today, and without from, it starts at the beginning of
+// the year of to.
+func getReportRange(year1, year2 int, loc *time.Location) (time.Time, time.Time, error) {
+	zone := loc
+	if zone == nil {
+		zone = time.UTC
+	}
+	start, _ := getYearRange(year1, loc)
+	_, end := getYearRange(year2, loc)
+	if *flagTo != "" {
+		to, err := time.ParseInLocation(dateFormat, *flagTo, zone)
+		if err != nil {
+			return start, end, fmt.Errorf("to must be a date, eg 2024-09-30: %s", err)
+		}
+		end = to.AddDate(0, 0, 1)
+		if *flagFrom == "" {
+			start, _ = getYearRange(to.Year(), loc)
+		}
+	}
+	if *flagFrom != "" {
+		from, err := time.ParseInLocation(dateFormat, *flagFrom, zone)
+		if err != nil {
+			return start, end, fmt.Errorf("from must be a date, eg 2024-03-15: %s", err)
+		}
+		start = from
+		if *flagTo == "" {
+			now := nowIn(loc)
+			end = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, zone)
+		}
+	}
+	if first, _ := getYearRange(epoch, loc); start.Before(first) {
+		start = first
+	}
+	if !start.Before(end) {
+		return start, end, fmt.Errorf("the report must start before it ends, not from %s to %s",
+			start.Format(dateFormat), end.AddDate(0, 0, -1).Format(dateFormat))
+	}
+	return start, end, nil
+}
+
+// getReportPeriods returns the periods of kind from start to end, leaving off any that haven't
+// started yet.
+func getReportPeriods(kind periodKind, start, end time.Time, loc *time.Location) []period {
+	periods := kind.split(start, end)
+	now := nowIn(loc)
+	for len(periods) > 1 && periods[len(periods)-1].start.After(now) {
+		periods = periods[:len(periods)-1]
+	}
+	return periods
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPeriods splits the same dates by each kind of period, across the end of a year.
+func TestPeriods(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		kind   periodKind
+		start  time.Time
+		end    time.Time
+		labels []string
+		first  time.Time // start of the first period, before it is clipped
+	}{
+		{periodKind{name: "year"}, date(2020, 12, 1), date(2022, 1, 1), []string{"2020", "2021"}, date(2020, 1, 1)},
+		{periodKind{name: "fiscal", fiscalStart: time.April}, date(2021, 1, 1), date(2022, 1, 1), []string{"FY2020/21", "FY2021/22"}, date(2020, 4, 1)},
+		{periodKind{name: "fiscal", fiscalStart: time.January}, date(2021, 1, 1), date(2022, 1, 1), []string{"FY2021"}, date(2021, 1, 1)},
+		{periodKind{name: "season"}, date(2020, 11, 1), date(2021, 3, 2), []string{"Autumn 2020", "Winter 2020/21", "Spring 2021"}, date(2020, 9, 1)},
+		{periodKind{name: "season"}, date(2021, 2, 1), date(2021, 3, 1), []string{"Winter 2020/21"}, date(2020, 12, 1)},
+		{periodKind{name: "quarter"}, date(2020, 12, 1), date(2021, 5, 1), []string{"2020-Q4", "2021-Q1", "2021-Q2"}, date(2020, 10, 1)},
+		{periodKind{name: "month"}, date(2020, 12, 15), date(2021, 2, 1), []string{"2020-12", "2021-01"}, date(2020, 12, 1)},
+		// 3 January 2021 is a Sunday in the last ISO week of 2020
+		{periodKind{name: "week"}, date(2021, 1, 3), date(2021, 1, 11), []string{"2020-W53", "2021-W01"}, date(2020, 12, 28)},
+		{periodKind{name: "range"}, date(2024, 3, 15), date(2024, 10, 1), []string{"2024-03-15 to 2024-09-30"}, date(2024, 3, 15)},
+	}
+	for _, c := range cases {
+		periods := c.kind.split(c.start, c.end)
+		if len(periods) != len(c.labels) {
+			t.Errorf("%s: expected %v, got %+v", c.kind.name, c.labels, periods)
+			continue
+		}
+		for i, p := range periods {
+			if p.label != c.labels[i] {
+				t.Errorf("%s: expected %s, got %s", c.kind.name, c.labels[i], p.label)
+			}
+			if i > 0 && !p.start.Equal(periods[i-1].end) {
+				t.Errorf("%s: %s doesn't start where %s ends", c.kind.name, p.label, periods[i-1].label)
+			}
+		}
+		if first := c.kind.floor(c.start); c.kind.name != "range" && !first.Equal(c.first) {
+			t.Errorf("%s: expected the first period to start on %s, got %s", c.kind.name, c.first, first)
+		}
+		// the periods are clipped to the dates asked for
+		last := periods[len(periods)-1]
+		if !periods[0].start.Equal(c.start) || !last.end.Equal(c.end) || periods[0].clipped != !c.start.Equal(c.first) {
+			t.Errorf("%s: expected the periods to run from %s to %s, got %+v", c.kind.name, c.start, c.end, periods)
+		}
+	}
+}
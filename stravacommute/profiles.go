@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+	"github.com/droppedbars/strava-commute-times/stravahelpers"
+)
+
+// athleteDistances are the distances of each period of the athlete authorized in a profile.
+type athleteDistances struct {
+	profile   string
+	athleteID int64
+	distances []stravaDistances
+}
+
+// heading returns the name the athlete is reported under.
+func (a athleteDistances) heading() string {
+	if a.athleteID == 0 {
+		return a.profile
+	}
+	return fmt.Sprintf("%s (athlete %d)", a.profile, a.athleteID)
+}
+
+// getProfiles reads the input flags profile and allProfiles, and returns the profiles to report on.
+func getProfiles() ([]string, error) {
+	if !*flagAllProfiles {
+		return []string{*flagProfile}, stravahelpers.CheckProfileName(*flagProfile)
+	}
+	if *flagImport != "" || *flagIngest != "" {
+		return nil, fmt.Errorf("-allProfiles can't be used with -import or -ingest, they only hold one athlete")
+	}
+	if !*flagOffline && (*flagTokenStore == "env" || *flagTokens != "") {
+		return nil, fmt.Errorf("-allProfiles can't be used with the env token store or -tokens, they only hold one athlete")
+	}
+	profiles, err := stravahelpers.ListProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("there are no profiles, run with -profile to authorize one")
+	}
+	return profiles, nil
+}
+
+// listProfiles prints the profiles that have tokens or activities stored, with the athlete each one
+// is for when it can be read without a passphrase.
+func listProfiles() error {
+	profiles, err := stravahelpers.ListProfiles()
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		fmt.Println("There are no profiles.")
+		return nil
+	}
+	for _, profile := range profiles {
+		athleteID := int64(0)
+		store, err := stravahelpers.ProfileTokenStore(profile, "file", "")
+		if err == nil {
+			if auth, err := store.Load(); err == nil {
+				athleteID = auth.AthleteID
+			}
+		}
+		fmt.Println(athleteDistances{profile: profile, athleteID: athleteID}.heading())
+	}
+	return nil
+}
+
+// removeProfile deletes a profile and its tokens.
+func removeProfile(profile string) error {
+	err := stravahelpers.RemoveProfile(profile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed profile %s\n", profile)
+	return nil
+}
+
+// profileTokenStore returns the token store of the profile, or the one selected by the tokens flag.
+func profileTokenStore(profile, passphrase string) (stravahelpers.TokenStore, error) {
+	if *flagTokens != "" || *flagTokenStore == "env" {
+		return stravahelpers.NewTokenStore(*flagTokenStore, *flagTokens, passphrase)
+	}
+	return stravahelpers.ProfileTokenStore(profile, *flagTokenStore, passphrase)
+}
+
+// deauthorizeProfile revokes the application's access to the profile's athlete on Strava and deletes
+// the profile's tokens.
+func deauthorizeProfile(ctx context.Context, profile string) error {
+	err := stravahelpers.CheckProfileName(profile)
+	if err != nil {
+		return err
+	}
+	tokenStore, err := profileTokenStore(profile, getPassphrase())
+	if err != nil {
+		return err
+	}
+	athleteID := int64(0)
+	if auth, err := tokenStore.Load(); err == nil {
+		athleteID = auth.AthleteID
+	}
+
+	client := stravahelpers.NewClient(stravahelpers.WithTokenStore(tokenStore))
+	err = client.Deauthorize(ctx)
+	if errors.Is(err, stravahelpers.ErrNoTokens) {
+		return fmt.Errorf("profile %s has no tokens, there is nothing to revoke", profile)
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Revoked access to %s and deleted its tokens.\n", athleteDistances{profile: profile, athleteID: athleteID}.heading())
+	if *flagTokenStore == "env" {
+		fmt.Printf("Remove %s and the other token variables from wherever they are set.\n", stravahelpers.EnvRefreshToken)
+	}
+	return nil
+}
+
+// profileClient returns an authenticated client for the profile. If the profile has no tokens yet
+// the athlete is asked to authorize the application.
+func profileClient(ctx context.Context, profile, passphrase string, clientOptions []stravahelpers.Option) (*stravahelpers.Client, error) {
+	tokenStore, err := profileTokenStore(profile, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.INFO.Println("Authenticating profile: ", profile)
+	options := append([]stravahelpers.Option{stravahelpers.WithTokenStore(tokenStore)}, clientOptions...)
+	client := stravahelpers.NewClient(options...)
+	err = client.Authenticate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", profile, err)
+	}
+	return client, nil
+}
+
+// getPassphrase returns the passphrase for the encrypted token store, read from
+// STRAVA_TOKEN_PASSPHRASE or asked for. The same passphrase is used for every profile.
+func getPassphrase() string {
+	if *flagTokenStore != "encrypted" {
+		return ""
+	}
+	passphrase := os.Getenv("STRAVA_TOKEN_PASSPHRASE")
+	if passphrase == "" {
+		fmt.Print("Token passphrase: ")
+		fmt.Scanln(&passphrase)
+	}
+	return passphrase
+}
+
+// combineDistances sums the distances and rides of each period of all the athletes, and of their
+// breakdowns. The athletes are all reported over the same periods.
+func combineDistances(athletes []athleteDistances) []stravaDistances {
+	var combined []stravaDistances
+	for _, athlete := range athletes {
+		combined = addDistances(combined, athlete.distances)
+	}
+	return combined
+}
+
+// addDistances adds the distances and rides of each period to the sums of the same period.
+func addDistances(sums, distances []stravaDistances) []stravaDistances {
+	for i, d := range distances {
+		if i == len(sums) {
+			sums = append(sums, stravaDistances{period: d.period})
+		}
+		sums[i].commute += d.commute
+		sums[i].pleasure += d.pleasure
+		sums[i].commuteRides += d.commuteRides
+		sums[i].pleasureRides += d.pleasureRides
+		sums[i].baseline += d.baseline
+		sums[i].times = sums[i].times.combine(d.times)
+		sums[i].breakdown = addDistances(sums[i].breakdown, d.breakdown)
+	}
+	return sums
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+// periodReport is the distances and rides of a period in the json report.
+type periodReport struct {
+	Label         string         `json:"label"`
+	From          string         `json:"from"` // first day of the period
+	To            string         `json:"to"`   // last day of the period, inclusive
+	Clipped       bool           `json:"clipped,omitempty"`
+	CommuteKm     float64        `json:"commute_km"`
+	PleasureKm    float64        `json:"pleasure_km"`
+	TotalKm       float64        `json:"total_km"`
+	CommuteRides  int            `json:"commute_rides"`
+	PleasureRides int            `json:"pleasure_rides"`
+	TotalRides    int            `json:"total_rides"`
+	BaselineKm    float64        `json:"baseline_km"` // how far the commute is over the period
+	CommuteTimes  *timesReport   `json:"commute_times,omitempty"`
+	Breakdown     []periodReport `json:"breakdown,omitempty"`
+}
+
+// timesReport is the time statistics of the commutes of a period in the json report, in seconds.
+type timesReport struct {
+	Moving         int64   `json:"moving"`
+	AverageMoving  int64   `json:"average_moving"`
+	Elapsed        int64   `json:"elapsed"`
+	AverageElapsed int64   `json:"average_elapsed"`
+	Stopped        int64   `json:"stopped"`
+	AverageStopped int64   `json:"average_stopped"`
+	Median         int64   `json:"median"` // of the elapsed time of each commute
+	P90            int64   `json:"p90"`
+	SpeedKmh       float64 `json:"speed_kmh"` // average moving speed
+}
+
+// newTimesReport converts the time statistics of the commutes, or returns nil if there were none.
+func newTimesReport(c commuteTimes, commutes int) *timesReport {
+	if commutes == 0 {
+		return nil
+	}
+	seconds := func(d time.Duration) int64 {
+		return int64(d.Round(time.Second).Seconds())
+	}
+	return &timesReport{
+		Moving:         seconds(c.moving),
+		AverageMoving:  seconds(c.averageMoving()),
+		Elapsed:        seconds(c.elapsed),
+		AverageElapsed: seconds(c.averageElapsed()),
+		Stopped:        seconds(c.stopped),
+		AverageStopped: seconds(c.averageStopped()),
+		Median:         seconds(c.percentile(0.5)),
+		P90:            seconds(c.percentile(0.9)),
+		SpeedKmh:       c.speed(),
+	}
+}
+
+// athleteReport is the periods of an athlete in the json report.
+type athleteReport struct {
+	Profile   string         `json:"profile"`
+	AthleteID int64          `json:"athlete_id,omitempty"`
+	Periods   []periodReport `json:"periods"`
+}
+
+// report is the json report, with the periods of every athlete, and the combined periods of all of
+// them when there is more than one.
+type report struct {
+	Period    string          `json:"period"`
+	Breakdown string          `json:"breakdown,omitempty"`
+	Athletes  []athleteReport `json:"athletes"`
+	Combined  []periodReport  `json:"combined,omitempty"`
+}
+
+// newPeriodReports converts the distances of each period, and their breakdown, for the json report.
+func newPeriodReports(distances []stravaDistances) []periodReport {
+	var reports []periodReport
+	for _, d := range distances {
+		reports = append(reports, periodReport{
+			Label:         d.label,
+			From:          d.start.Format(dateFormat),
+			To:            d.end.AddDate(0, 0, -1).Format(dateFormat),
+			Clipped:       d.clipped,
+			CommuteKm:     d.commute,
+			PleasureKm:    d.pleasure,
+			TotalKm:       d.commute + d.pleasure,
+			CommuteRides:  d.commuteRides,
+			PleasureRides: d.pleasureRides,
+			TotalRides:    d.commuteRides + d.pleasureRides,
+			BaselineKm:    d.baseline,
+			CommuteTimes:  newTimesReport(d.times, d.commuteRides),
+			Breakdown:     newPeriodReports(d.breakdown),
+		})
+	}
+	return reports
+}
+
+// newReport converts the distances of the athletes, and their combined distances if there are any,
+// for the json and csv reports.
+func newReport(athletes []athleteDistances, combined []stravaDistances, kind, breakdown periodKind) report {
+	r := report{Period: kind.name, Breakdown: breakdown.name, Combined: newPeriodReports(combined)}
+	for _, athlete := range athletes {
+		r.Athletes = append(r.Athletes, athleteReport{Profile: athlete.profile, AthleteID: athlete.athleteID,
+			Periods: newPeriodReports(athlete.distances)})
+	}
+	return r
+}
+
+// writeJSON writes the report as indented json.
+func (r report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// csvHeader is the header row of the csv report. Each period has a row with an empty breakdown
+// column, followed by a row for each month or week of its breakdown. The commute times are in
+// seconds, and empty for periods without commutes.
+var csvHeader = []string{"profile", "athlete_id", "period", "breakdown", "from", "to",
+	"commute_km", "pleasure_km", "total_km", "commute_rides", "pleasure_rides", "total_rides", "baseline_km",
+	"commute_moving", "commute_average_moving", "commute_elapsed", "commute_average_elapsed",
+	"commute_stopped", "commute_average_stopped", "commute_median", "commute_p90", "commute_speed_kmh"}
+
+// writeCSV writes the report as csv, the combined periods under the profile "all".
+func (r report) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader)
+	for _, athlete := range r.Athletes {
+		writeCSVPeriods(writer, athlete.Profile, athlete.AthleteID, athlete.Periods)
+	}
+	if len(r.Combined) > 0 {
+		writeCSVPeriods(writer, "all", 0, r.Combined)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeCSVPeriods writes a row for each of the athlete's periods and their breakdown.
+func writeCSVPeriods(writer *csv.Writer, profile string, athleteID int64, periods []periodReport) {
+	id := ""
+	if athleteID != 0 {
+		id = strconv.FormatInt(athleteID, 10)
+	}
+	row := func(period, part string, p periodReport) {
+		record := []string{profile, id, period, part, p.From, p.To,
+			strconv.FormatFloat(p.CommuteKm, 'f', 3, 64), strconv.FormatFloat(p.PleasureKm, 'f', 3, 64),
+			strconv.FormatFloat(p.TotalKm, 'f', 3, 64), strconv.Itoa(p.CommuteRides), strconv.Itoa(p.PleasureRides),
+			strconv.Itoa(p.TotalRides), strconv.FormatFloat(p.BaselineKm, 'f', 3, 64)}
+		if times := p.CommuteTimes; times != nil {
+			for _, seconds := range []int64{times.Moving, times.AverageMoving, times.Elapsed, times.AverageElapsed,
+				times.Stopped, times.AverageStopped, times.Median, times.P90} {
+				record = append(record, strconv.FormatInt(seconds, 10))
+			}
+			record = append(record, strconv.FormatFloat(times.SpeedKmh, 'f', 3, 64))
+		}
+		for len(record) < len(csvHeader) {
+			record = append(record, "")
+		}
+		writer.Write(record)
+	}
+	for _, p := range periods {
+		row(p.Label, "", p)
+		for _, part := range p.Breakdown {
+			row(p.Label, part.Label, part)
+		}
+	}
+}
+
+// writeReportFile creates the file and writes the report to it with write.
+func writeReportFile(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to write %s: %s", path, err)
+	}
+	fmt.Printf("Wrote the report to %s\n", path)
+	return nil
+}
+
+// writeReports writes the json and csv reports asked for with the input flags json and csv.
+func writeReports(athletes []athleteDistances, combined []stravaDistances, kind, breakdown periodKind) {
+	r := newReport(athletes, combined, kind, breakdown)
+	if *flagJSON != "" {
+		err := writeReportFile(*flagJSON, r.writeJSON)
+		if err != nil {
+			logger.ERROR.Fatalln(err)
+		}
+	}
+	if *flagCSV != "" {
+		err := writeReportFile(*flagCSV, r.writeCSV)
+		if err != nil {
+			logger.ERROR.Fatalln(err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestReport writes the distances of two athletes and their breakdown as json and csv.
+func TestReport(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	year := stravaDistances{period: period{label: "2021", start: start, end: start.AddDate(1, 0, 0)},
+		commute: 22, pleasure: 30, commuteRides: 2, pleasureRides: 1, baseline: 5875,
+		times: commuteTimes{moving: time.Hour, elapsed: 80 * time.Minute, stopped: 20 * time.Minute, timed: 2, timedKm: 22,
+			durations: []time.Duration{30 * time.Minute, 50 * time.Minute}}}
+	year.breakdown = []stravaDistances{
+		{period: period{label: "2021-01", start: start, end: start.AddDate(0, 1, 0)}, commute: 22, pleasure: 30, commuteRides: 2, pleasureRides: 1},
+		{period: period{label: "2021-02", start: start.AddDate(0, 1, 0), end: start.AddDate(0, 2, 0)}},
+	}
+	athletes := []athleteDistances{
+		{profile: "alice", athleteID: 42, distances: []stravaDistances{year}},
+		{profile: "bob", distances: []stravaDistances{year}},
+	}
+	r := newReport(athletes, combineDistances(athletes), periodKind{name: "year"}, periodKind{name: "month"})
+
+	var buf bytes.Buffer
+	if err := r.writeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Athletes) != 2 || decoded.Athletes[0].AthleteID != 42 || decoded.Breakdown != "month" {
+		t.Errorf("unexpected athletes: %s", buf.String())
+	}
+	combined := decoded.Combined[0]
+	if combined.TotalKm != 104 || combined.TotalRides != 6 || combined.BaselineKm != 11750 || combined.To != "2021-12-31" || combined.Breakdown[1].To != "2021-02-28" {
+		t.Errorf("unexpected combined period: %+v", combined)
+	}
+	if times := combined.CommuteTimes; times == nil || times.Moving != 7200 || times.AverageStopped != 600 || times.Median != 2400 || times.SpeedKmh != 22 {
+		t.Errorf("unexpected combined commute times: %+v", times)
+	}
+	if decoded.Athletes[0].Periods[0].Breakdown[1].CommuteTimes != nil {
+		t.Error("expected a month without commutes to have no commute times")
+	}
+
+	buf.Reset()
+	if err := r.writeCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a header, and a row for the year and each month for each athlete and the combined athletes
+	if len(rows) != 10 {
+		t.Fatalf("expected 10 rows, got %v", rows)
+	}
+	if row := rows[2]; row[0] != "alice" || row[1] != "42" || row[2] != "2021" || row[3] != "2021-01" || row[6] != "22.000" || row[9] != "2" {
+		t.Errorf("unexpected breakdown row: %v", row)
+	}
+	if row := rows[3]; len(row) != len(csvHeader) || row[13] != "" {
+		t.Errorf("expected the times of a month without commutes to be empty: %v", row)
+	}
+	if row := rows[7]; row[0] != "all" || row[1] != "" || row[3] != "" || row[8] != "104.000" || row[11] != "6" || row[12] != "11750.000" || row[13] != "7200" || row[21] != "22.000" {
+		t.Errorf("unexpected combined row: %v", row)
+	}
+}
@@ -7,153 +7,243 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"sort"
-	"strconv"
-	"sync"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/droppedbars/strava-commute-times/logger"
 	"github.com/droppedbars/strava-commute-times/stravahelpers"
 )
 
-const annualCommuteKm = 5875 // assumes 25km/day, 5 days a week, 5 weeks of no riding per year
-const hoursInYear = 24 * 365 // simplistic, ignores leap years
-const epoch = 2009           // when strava started, so there should never be data before this
+const epoch = 2009 // when strava started, so there should never be data before this
 
 var flagYear1 = flag.Int("startYear", time.Now().Year(), "First year to run the commute numbers for. Defaults to current year.")
 var flagYear2 = flag.Int("endYear", time.Now().Year(), "Last year to run the commute numbers for. Defaults to current year.")
+var flagAuthPort = flag.Int("authPort", 0, "Localhost port to receive the Strava authorization on. Defaults to 0, which asks for the URL to be pasted instead.")
+var flagScopes = flag.String("scopes", "", "Comma separated Strava scopes to request when authorizing, eg read,activity:read_all. Defaults to the Scopes in ./api_client_secrets.json, or activity:read_all.")
+var flagTokenStore = flag.String("tokenStore", "file", "Where the Strava tokens are kept: file, encrypted (passphrase from STRAVA_TOKEN_PASSPHRASE or prompted for), or env (STRAVA_REFRESH_TOKEN and friends).")
+var flagTokens = flag.String("tokens", "", "File the tokens are kept in. Defaults to tokens.json, or tokens.enc for the encrypted store, in the profile's directory. With the env store, the file the new token variables are written to when the refresh token changes, eg tokens.env, otherwise they are only named so they can be updated.")
+var flagProfile = flag.String("profile", stravahelpers.DefaultProfile, "Profile of the athlete to report on. Each athlete authorizes the application into their own profile, kept in ./profiles/<name>. The default profile is kept in the current directory.")
+var flagAllProfiles = flag.Bool("allProfiles", false, "Report on every profile, each athlete on their own and then all of them combined.")
+var flagListProfiles = flag.Bool("listProfiles", false, "List the profiles and exit.")
+var flagRemoveProfile = flag.String("removeProfile", "", "Remove the named profile and its tokens, and exit.")
+var flagDeauthorize = flag.Bool("deauthorize", false, "Revoke the application's access to the athlete of -profile on Strava, delete the tokens, and exit.")
+var flagResyncDays = flag.Int("resyncDays", 7, "Activities are kept in the profile's activities.json and only new ones are fetched from Strava. Activities that started within this many days of the newest stored one are fetched again to pick up edits, such as marking a ride as a commute. -1 fetches them all again.")
+var flagOffline = flag.Bool("offline", false, "Report from the stored activities without contacting Strava, so no tokens or secrets are needed. Warns how long ago the activities were synced.")
+var flagImport = flag.String("import", "", "Strava export to import into the profile's stored activities before reporting, either the zip from Download your data or the directory it was extracted to. Use with -offline to report without an API application.")
+var flagIngest = flag.String("ingest", "", "Directory of GPX, TCX and FIT files, such as from a device that doesn't sync to Strava, to merge into the profile's stored activities before reporting. Subdirectories are included.")
+var flagWatch = flag.Bool("watch", false, "With -ingest, keep watching the directory and ingest new files as they appear, instead of reporting.")
+var flagWatchInterval = flag.Duration("watchInterval", 30*time.Second, "How often -watch checks the directory for new files.")
+var flagCommutePlaces = flag.String("commutePlaces", "", "Places commutes start and end, as semicolon separated latitude,longitude pairs, eg home and work. An ingested activity that goes from one to another, or is named as a commute, is a commute.")
+var flagCommuteRadius = flag.Float64("commuteRadius", stravahelpers.DefaultCommuteRadius, "How close to a commute place, in meters, an ingested activity must start or end.")
+var flagRateLimit = flag.String("rateLimit", "wait", "What to do when the Strava rate limit is reached: wait for it to reset, or fail.")
+var flagTimezone = flag.String("timezone", "", "IANA timezone the years are reported in, eg America/Vancouver. Defaults to each activity's own local time where it was recorded.")
+var flagAthleteTimezone = flag.String("athleteTimezone", "", "IANA timezone of the athlete, eg America/Vancouver, kept in the profile. Activities from -import and -ingest don't say where they were recorded, so they are reported in it. Syncing with Strava replaces it with the timezone of the athlete's newest activity.")
+var flagPeriod = flag.String("period", "year", "What to total the distances by: year, fiscal (years starting in -fiscalStart), season (meteorological, winter starting in December), quarter, month, week (ISO weeks starting on Monday), or range (the whole report as one).")
+var flagFiscalStart = flag.Int("fiscalStart", 4, "Month, from 1 to 12, that fiscal years start in with -period fiscal.")
+var flagFrom = flag.String("from", "", "First day to report on, eg 2024-03-15, instead of -startYear. Without -to the report runs to today.")
+var flagTo = flag.String("to", "", "Last day to report on, eg 2024-09-30, instead of -endYear. Without -from the report starts at the beginning of its year.")
+var flagBreakdown = flag.String("breakdown", "", "Break each period down into a table of the distances and rides of each month or week (ISO weeks), which is charted as well.")
+var flagJSON = flag.String("json", "", "File to write the distances and rides of each period, and their breakdown, to as json.")
+var flagCSV = flag.String("csv", "", "File to write the distances and rides of each period, and their breakdown, to as csv.")
+var flagBaseline = flag.String("baseline", "", "Commute baseline file that the commute by bike is measured against, for every profile. Defaults to commute_baseline.json in the profile's directory, or 25km a day, 5 days a week with 5 weeks off if there isn't one.")
+var flagRecord = flag.String("record", "", "File to record every call to Strava and its response in, with the tokens and secrets redacted, to help reproduce a problem.")
 
+// stravaDistances are the distances ridden in a period, and how many rides they were.
 type stravaDistances struct {
-	year     int
-	commute  float64
-	pleasure float64
+	period
+	commute       float64
+	pleasure      float64
+	commuteRides  int
+	pleasureRides int
+	baseline      float64           // how far the commute is over the period, in kilometers
+	times         commuteTimes      // of the commutes
+	breakdown     []stravaDistances // the months or weeks within the period, when asked for with -breakdown
 }
 
-// activityDistanceTotals takes an array of Strava activities (in the format returned by Strava)
-// and returns the total distance traveled and the total commute distance traveled. Both are
-// provided in kilometers.
-func ridingDistanceTotals(allActivities []map[string]interface{}) (float64, float64) {
-	commute := 0.0
-	total := 0.0
-
-	for j := 0; j < len(allActivities); j++ {
-		logger.TRACE.Println("Activity Name: ", allActivities[j]["name"].(string))
-		if allActivities[j]["type"] == "Ride" || allActivities[j]["type"] == "EBikeRide" {
-			distance := allActivities[j]["distance"].(float64) / 1000 // convert m to km
-			total += distance
-			if allActivities[j]["commute"].(bool) == true {
-				commute += distance
+// addRides takes an array of Strava activities and adds the distance of the rides to the commute
+// or pleasure distance, in kilometers, and counts them.
+func (d *stravaDistances) addRides(allActivities []stravahelpers.SummaryActivity) {
+	for _, activity := range allActivities {
+		logger.TRACE.Println("Activity Name: ", activity.Name)
+		if activity.IsRide() {
+			distance := activity.Distance / 1000 // convert m to km
+			if activity.Commute {
+				d.commute += distance
+				d.commuteRides++
+				d.times.add(activity)
+			} else {
+				d.pleasure += distance
+				d.pleasureRides++
 			}
 		}
 	}
-	return total, commute
 }
 
-// getActivities returns an array of Strava activities given a date range and accessToken.
-// The dates are provided as time since epoc.
-func getRidingActivities(startDate uint64, endDate uint64) []map[string]interface{} {
-	var allActivities []map[string]interface{}
-
-	for i := 1; ; i++ { // strava pages start at 1
-		activitiyListParams := map[string]uint64{
-			"before":   endDate,
-			"after":    startDate,
-			"page":     uint64(i),
-			"per_page": 200,
-		}
-		arrayJSONResponse, err := stravahelpers.StravaAPIGetArray(stravahelpers.StravaListActivitiesPath, activitiyListParams)
-		if err != nil {
-			logger.ERROR.Fatal(err)
-		}
-		if len(arrayJSONResponse) == 0 { // empty response, so no more data
-			break
-		}
-		allActivities = append(allActivities, arrayJSONResponse...)
-		logger.DEBUG.Println("Page: ", i)
-		logger.TRACE.Println("API call response page: "+strconv.Itoa(i)+": ", arrayJSONResponse)
-		logger.TRACE.Println("\n\nNumber of responses: ", len(arrayJSONResponse))
-		//logger.TRACE.Println("\n\nOne response: ", arrayJSONResponse[0])
+// syncActivities brings the activity store up to date with Strava, back to the start of the report.
+func syncActivities(ctx context.Context, client *stravahelpers.Client, store *stravahelpers.ActivityStore, start time.Time) error {
+	from := getSyncFrom(start)
+	resync := time.Duration(*flagResyncDays) * 24 * time.Hour
+	if *flagResyncDays < 0 {
+		resync = -1
 	}
-	return allActivities
+	fetched, err := client.SyncActivities(ctx, store, from, resync)
+	if err != nil {
+		return err
+	}
+	logger.INFO.Printf("Fetched %d activities, %d are stored\n", fetched, store.Len())
+	return nil
 }
 
-// getYearRange given a year integer will return the starting Time object and ending Time object for that
-// year. If the year provided is invalid, or the creation of the Time object fails for any reason a
-// fatal error is logged and the application is terminated.
-func getYearRange(year int) (time.Time, time.Time) {
-	yearStr := strconv.Itoa(year)
-	var startTime time.Time
-	var endTime time.Time
-	var err error
-
-	startTime, err = time.Parse(time.RFC3339, yearStr+"-01-01T12:00:01-08:00")
+// importExport adds the activities from a Strava export to the activity store, and saves it.
+func importExport(profile string, store *stravahelpers.ActivityStore, exportPath string) error {
+	activities, err := stravahelpers.ImportExport(exportPath)
 	if err != nil {
-		logger.ERROR.Fatalln(err)
+		return err
 	}
-	endTime, err = time.Parse(time.RFC3339, yearStr+"-12-31T11:59:59-08:00")
+	store.Import(activities...)
+	err = store.Save()
 	if err != nil {
-		logger.ERROR.Fatalln(err)
+		return err
 	}
-	return startTime, endTime
+	fmt.Printf("Imported %d activities from %s into %s\n", len(activities), exportPath, profile)
+	return nil
 }
 
-// returnYearResults populates a single year into the multiYears global array
-func returnYearResults(yearInt int, multiYears map[int]stravaDistances, mu *sync.Mutex, wg *sync.WaitGroup) {
-	defer wg.Done()
-	startTime, endTime := getYearRange(yearInt)
-
-	allActivities := getRidingActivities(uint64(startTime.Unix()), uint64(endTime.Unix()))
-	total, commute := ridingDistanceTotals(allActivities)
-	distances := stravaDistances{year: yearInt, commute: commute, pleasure: total - commute}
-	mu.Lock()
-	multiYears[yearInt] = distances
-	mu.Unlock()
+// setAthleteTimezone sets the timezone of the profile's athlete in the activity store, and saves it.
+func setAthleteTimezone(profile string, store *stravahelpers.ActivityStore, name string) error {
+	err := store.SetTimezone(name)
+	if err != nil {
+		return fmt.Errorf("athleteTimezone: %s", err)
+	}
+	logger.INFO.Printf("Timezone of %s is %s\n", profile, name)
+	return store.Save()
 }
 
-// getStravaDistances spins off a go thread for each requested year, and each one builds up the
-// summary of distance information for that year and adds it to the global multiYears array.
-func getStravaDistances(year1, year2 int, multiYears map[int]stravaDistances, mu *sync.Mutex, wg *sync.WaitGroup) {
-	for i := year1; i <= year2; i++ {
-		wg.Add(1)
-		go returnYearResults(i, multiYears, mu, wg)
+// warnOffline reports how stale the stored activities are when running offline, and whether they go
+// back to the start of the report. Imported activities are reported as such, since how complete
+// they are depends on when the export was made. It returns an error if there are no stored
+// activities.
+func warnOffline(profile string, store *stravahelpers.ActivityStore, start time.Time) error {
+	syncedAt := store.SyncedAt()
+	if store.Len() == 0 {
+		return fmt.Errorf("profile %s has no stored activities, run without -offline to fetch them, or -import an export", profile)
+	}
+	if syncedAt.IsZero() {
+		importedAt := store.ImportedAt()
+		fmt.Printf("Offline: the activities of %s were imported on %s and have never been synced with Strava.\n",
+			profile, importedAt.Local().Format("2006-01-02 15:04"))
+		if store.Timezone() == "" && *flagTimezone == "" {
+			fmt.Printf("The timezone of %s isn't known, so the imported activities are reported in UTC, use -athleteTimezone to set it.\n", profile)
+		}
+		return nil
+	}
+
+	age := time.Since(syncedAt)
+	ageText := fmt.Sprintf("%d days", int(age.Hours()/24))
+	if age < 48*time.Hour {
+		ageText = age.Round(time.Minute).String()
 	}
+	logger.WARN.Printf("Offline, the activities of %s were last synced %s ago\n", profile, ageText)
+	fmt.Printf("Offline: the activities of %s were last synced %s ago, on %s. Newer activities and edits are missing.\n",
+		profile, ageText, syncedAt.Local().Format("2006-01-02 15:04"))
+
+	from := getSyncFrom(start)
+	if syncedFrom := store.SyncedFrom(); from.Before(syncedFrom) {
+		fmt.Printf("  Activities before %s are not stored, so earlier periods are incomplete.\n", syncedFrom.Local().Format("2006-01-02"))
+	}
+	return nil
 }
 
-// outputStravaDistances prints out the results in the stravaDistances structs sorted by year
-func outputStravaDistances(multiYears map[int]stravaDistances) {
-	var years []int
-	for year := range multiYears {
-		years = append(years, year)
+// getStravaDistances builds up the summary of distance information for each period from the stored
+// activities, broken down into the periods of breakdown unless its name is empty, with the commute
+// baseline of each. Activities are in the period they started in, in loc or in their own local time
+// if it is nil.
+func getStravaDistances(store *stravahelpers.ActivityStore, periods []period, breakdown periodKind, baseline commuteBaseline,
+	loc *time.Location) []stravaDistances {
+	var distances []stravaDistances
+	athleteLoc := store.Location()
+	for _, p := range periods {
+		activities := localActivities(store, p.start, p.end, loc)
+		d := stravaDistances{period: p, baseline: baseline.km(p.start, p.end)}
+		d.addRides(activities)
+		if breakdown.name != "" {
+			for _, part := range breakdown.split(p.start, p.end) {
+				partDistances := stravaDistances{period: part, baseline: baseline.km(part.start, part.end)}
+				partDistances.addRides(startedBetween(activities, part.start, part.end, loc, athleteLoc))
+				d.breakdown = append(d.breakdown, partDistances)
+			}
+		}
+		distances = append(distances, d)
 	}
-	sort.Ints(years)
-	for _, yearInt := range years {
-		commute := multiYears[yearInt].commute
-		total := multiYears[yearInt].commute + multiYears[yearInt].pleasure
+	return distances
+}
 
-		startTime, endTime := getYearRange(yearInt)
+// outputStravaDistances prints out the distances of each period, in order, followed by the breakdown
+// of each period if there is one. The periods are in loc, or in each activity's local time if it is
+// nil.
+func outputStravaDistances(distances []stravaDistances, kind, breakdown periodKind, loc *time.Location) {
+	unit := kind.unit()
+	for _, d := range distances {
+		commute := d.commute
+		total := d.commute + d.pleasure
+		baseline := d.baseline
 
-		logger.INFO.Println("Commute time range start: ", startTime)
-		logger.INFO.Println("Commute time range end: ", endTime)
+		logger.INFO.Println("Commute time range start: ", d.start)
+		logger.INFO.Println("Commute time range end: ", d.end)
 
-		percentageOfYear := 1.0
-		fullYear := true
-		if endTime.Unix() > time.Now().Unix() {
-			percentageOfYear = time.Since(startTime).Hours() / hoursInYear
-			fullYear = false
+		percentageOfPeriod := 1.0
+		fullPeriod := true
+		if now := nowIn(loc); d.end.After(now) {
+			percentageOfPeriod = now.Sub(d.start).Hours() / d.end.Sub(d.start).Hours()
+			fullPeriod = false
+		}
+		heading := d.label
+		if d.clipped {
+			heading += " (" + d.dateRange() + ")"
 		}
-		fmt.Println("\n" + strconv.Itoa(yearInt))
+		fmt.Println("\n" + heading)
 		fmt.Printf("Total Distance (km): %.1f\n", total)
-		if !fullYear {
-			fmt.Printf("  Estimated end of year distance (km): %.1f\n", total/percentageOfYear)
+		if !fullPeriod && percentageOfPeriod > 0 {
+			fmt.Printf("  Estimated end of %s distance (km): %.1f\n", unit, total/percentageOfPeriod)
 		}
-		fmt.Printf("Total Commute (km): %.1f, %.1f%%\n", commute, (commute/total)*100)
-		fmt.Printf("  Percentage of commute by bike: %.1f%%\n", (commute/annualCommuteKm)*100)
-		if !fullYear {
-			fmt.Printf("  Estimated percentage of commute by bike for year: %.1f%%\n", (commute/annualCommuteKm/percentageOfYear)*100)
+		fmt.Printf("Total Commute (km): %.1f, %.1f%%\n", commute, percentOf(commute, total))
+		if baseline > 0 {
+			fmt.Printf("  Percentage of commute by bike: %.1f%% of %.1f km\n", (commute/baseline)*100, baseline)
+			if !fullPeriod && percentageOfPeriod > 0 {
+				fmt.Printf("  Estimated percentage of commute by bike for %s: %.1f%%\n", unit, (commute/baseline/percentageOfPeriod)*100)
+			}
+		} else {
+			fmt.Println("  Percentage of commute by bike: there is no commute in the baseline")
+		}
+		fmt.Printf("Total Pleasure (km): %.1f, %.1f%%\n", total-commute, percentOf(total-commute, total))
+		if d.commuteRides > 0 {
+			outputCommuteTimes(d.times, d.commuteRides)
+		}
+		if len(d.breakdown) > 0 {
+			outputBreakdown(d.breakdown, breakdown)
 		}
-		fmt.Printf("Total Pleasure (km): %.1f, %.1f%%\n", total-commute, ((total-commute)/total)*100)
+	}
+}
+
+// percentOf returns part as a percentage of total, or 0 if the total is 0, such as for a period
+// without any rides.
+func percentOf(part, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return part / total * 100
+}
+
+// outputBreakdown prints a table of the distances and rides of each month or week within a period.
+func outputBreakdown(breakdown []stravaDistances, kind periodKind) {
+	fmt.Printf("  %-9s  %12s  %5s  %13s  %5s  %10s  %5s\n", title(kind.unit()), "Commute (km)", "Rides", "Pleasure (km)", "Rides", "Total (km)", "Rides")
+	for _, d := range breakdown {
+		fmt.Printf("  %-9s  %12.1f  %5d  %13.1f  %5d  %10.1f  %5d\n", d.label, d.commute, d.commuteRides, d.pleasure, d.pleasureRides,
+			d.commute+d.pleasure, d.commuteRides+d.pleasureRides)
 	}
 }
 
@@ -180,25 +270,195 @@ func getYears() (int, int) {
 	return year1, year2
 }
 
+// getRateLimitPolicy reads the input flag rateLimit and returns the matching policy.
+func getRateLimitPolicy() stravahelpers.RateLimitPolicy {
+	switch *flagRateLimit {
+	case "wait":
+		return stravahelpers.RateLimitWait
+	case "fail":
+		return stravahelpers.RateLimitFail
+	}
+	logger.ERROR.Fatalln("rateLimit must be either wait or fail, not: ", *flagRateLimit)
+	return stravahelpers.RateLimitWait
+}
+
+// exitOnError logs the error and exits. If the application was interrupted it is reported as such
+// rather than as an error.
+func exitOnError(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		logger.WARN.Println("Interrupted: ", err)
+		fmt.Println("Interrupted")
+		logger.Close()
+		os.Exit(1)
+	}
+	if stravahelpers.IsUnauthorized(err) {
+		fmt.Println("Strava rejected the access token. Run with -removeProfile and then run again to re-authorize the application.")
+	} else if stravahelpers.IsRateLimited(err) {
+		fmt.Println("The Strava rate limit has been reached. Try again later, or run with -rateLimit wait.")
+	}
+	logger.ERROR.Fatalln(err)
+}
+
 // main execution function.
 func main() {
 	logger.SetLogging(true, logger.DebugLevel)
+	logger.StopInterruptHandler()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
 	flag.Parse()
+	if *flagListProfiles {
+		err := listProfiles()
+		if err != nil {
+			logger.ERROR.Fatalln(err)
+		}
+		logger.Close()
+		return
+	}
+	if *flagRemoveProfile != "" {
+		err := removeProfile(*flagRemoveProfile)
+		if err != nil {
+			logger.ERROR.Fatalln(err)
+		}
+		logger.Close()
+		return
+	}
+	if *flagDeauthorize {
+		err := deauthorizeProfile(ctx, *flagProfile)
+		if err != nil {
+			exitOnError(ctx, err)
+		}
+		logger.Close()
+		return
+	}
 	year1, year2 := getYears()
+	profiles, err := getProfiles()
+	if err != nil {
+		logger.ERROR.Fatalln(err)
+	}
 
-	err := stravahelpers.StravaAuthenticate()
+	loc, err := getTimezone()
+	if err != nil {
+		logger.ERROR.Fatalln(err)
+	}
+	kind, err := getPeriodKind()
+	if err != nil {
+		logger.ERROR.Fatalln(err)
+	}
+	start, end, err := getReportRange(year1, year2, loc)
+	if err != nil {
+		logger.ERROR.Fatalln(err)
+	}
+	periods := getReportPeriods(kind, start, end, loc)
+	breakdown, err := getBreakdownKind()
 	if err != nil {
 		logger.ERROR.Fatalln(err)
 	}
 
-	var multiYears = make(map[int]stravaDistances)
-	var mu sync.Mutex
-	var wg sync.WaitGroup
+	clientOptions := []stravahelpers.Option{stravahelpers.WithRateLimitPolicy(getRateLimitPolicy())}
+	if *flagRecord != "" {
+		fmt.Printf("Recording the calls to Strava in %s\n", *flagRecord)
+		recorder := stravahelpers.NewRecorder(*flagRecord, nil)
+		defer recorder.Close()
+		clientOptions = append(clientOptions, stravahelpers.WithTransport(recorder))
+	}
+	if *flagAuthPort > 0 {
+		clientOptions = append(clientOptions, stravahelpers.WithLoopbackAuth(*flagAuthPort))
+	}
+	scopes, err := stravahelpers.ParseScopes(*flagScopes)
+	if err != nil {
+		logger.ERROR.Fatalln(err)
+	}
+	if len(scopes) > 0 {
+		clientOptions = append(clientOptions, stravahelpers.WithScopes(scopes...))
+	}
+	passphrase := ""
+	if !*flagOffline {
+		passphrase = getPassphrase()
+	}
 
-	getStravaDistances(year1, year2, multiYears, &mu, &wg)
-	wg.Wait()
-	outputStravaDistances(multiYears)
-	logger.DEBUG.Printf("All data: len=%d %v\n", len(multiYears), multiYears)
-	graphResults(multiYears)
+	// authorizing may need the athlete at the keyboard, so the profiles are authenticated one at a time
+	var athletes []athleteDistances
+	for _, profile := range profiles {
+		store, err := stravahelpers.ProfileActivityStore(profile)
+		if err != nil {
+			logger.ERROR.Fatalln(err)
+		}
+		if *flagImport != "" {
+			err = importExport(profile, store, *flagImport)
+			if err != nil {
+				logger.ERROR.Fatalln(err)
+			}
+		}
+		if *flagIngest != "" {
+			classifier, err := getCommuteClassifier()
+			if err != nil {
+				logger.ERROR.Fatalln(err)
+			}
+			if *flagWatch {
+				err = watchDirectory(ctx, profile, store, *flagIngest, classifier, *flagWatchInterval)
+				if err != nil {
+					logger.ERROR.Fatalln(err)
+				}
+				logger.Close()
+				return
+			}
+			ingested, err := ingestDirectory(store, *flagIngest, classifier, map[string]time.Time{})
+			if err != nil {
+				logger.ERROR.Fatalln(err)
+			}
+			fmt.Printf("Ingested %d activities from %s into %s\n", ingested, *flagIngest, profile)
+		}
+		if *flagAthleteTimezone != "" {
+			err = setAthleteTimezone(profile, store, *flagAthleteTimezone)
+			if err != nil {
+				logger.ERROR.Fatalln(err)
+			}
+		}
+		var athleteID int64
+		if *flagOffline {
+			err = warnOffline(profile, store, start)
+			if err != nil {
+				logger.ERROR.Fatalln(err)
+			}
+			athleteID = store.AthleteID()
+		} else {
+			client, err := profileClient(ctx, profile, passphrase, clientOptions)
+			if err != nil {
+				exitOnError(ctx, err)
+			}
+			err = syncActivities(ctx, client, store, start)
+			if err != nil {
+				exitOnError(ctx, fmt.Errorf("profile %s: %w", profile, err))
+			}
+			athleteID = client.AthleteID()
+		}
+		baseline, err := loadBaseline(profile)
+		if err != nil {
+			logger.ERROR.Fatalln(err)
+		}
+		distances := getStravaDistances(store, periods, breakdown, baseline, loc)
+		athletes = append(athletes, athleteDistances{profile: profile, athleteID: athleteID, distances: distances})
+	}
+
+	if len(athletes) == 1 {
+		outputStravaDistances(athletes[0].distances, kind, breakdown, loc)
+		logger.DEBUG.Printf("All data: len=%d %v\n", len(athletes[0].distances), athletes[0].distances)
+		graphDistances(athletes[0].distances, kind, breakdown, "")
+		writeReports(athletes, nil, kind, breakdown)
+		logger.Close()
+		return
+	}
+	for _, athlete := range athletes {
+		fmt.Println("\n== " + athlete.heading() + " ==")
+		outputStravaDistances(athlete.distances, kind, breakdown, loc)
+		graphDistances(athlete.distances, kind, breakdown, athlete.profile)
+	}
+	combined := combineDistances(athletes)
+	fmt.Println("\n== All athletes ==")
+	outputStravaDistances(combined, kind, breakdown, loc)
+	logger.DEBUG.Printf("All data: len=%d %v\n", len(combined), combined)
+	graphDistances(combined, kind, breakdown, "")
+	writeReports(athletes, combined, kind, breakdown)
+	logger.Close()
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/stravahelpers"
+	"github.com/droppedbars/strava-commute-times/stravahelpers/stravatest"
+)
+
+// testActivity returns an activity for the fake Strava.
+func testActivity(id int64, activityType string, km float64, commute bool, start time.Time) stravahelpers.DetailedActivity {
+	var activity stravahelpers.DetailedActivity
+	activity.ID = id
+	activity.Type = activityType
+	activity.Distance = km * 1000
+	activity.Commute = commute
+	activity.StartDate = start
+	return activity
+}
+
+// TestSyncAndReport syncs the activities from a fake Strava into a store and totals them, then
+// picks up an edit and a deletion on a later sync.
+func TestSyncAndReport(t *testing.T) {
+	server := stravatest.NewServer(stravatest.Fixtures{Activities: []stravahelpers.DetailedActivity{
+		testActivity(1, "Ride", 10, true, time.Date(2021, 3, 1, 16, 0, 0, 0, time.UTC)),
+		testActivity(2, "Run", 5, false, time.Date(2021, 3, 2, 16, 0, 0, 0, time.UTC)),
+		testActivity(3, "Ride", 20, false, time.Date(2021, 6, 1, 16, 0, 0, 0, time.UTC)),
+		testActivity(4, "EBikeRide", 8, true, time.Date(2021, 6, 2, 16, 0, 0, 0, time.UTC)),
+		testActivity(5, "Ride", 50, false, time.Date(2020, 6, 1, 16, 0, 0, 0, time.UTC)),
+	}})
+	defer server.Close()
+	client := stravahelpers.NewClient(append(server.ClientOptions(),
+		stravahelpers.WithTokenSource(stravahelpers.StaticToken(server.IssueTokens().AccessToken)))...)
+	store, err := stravahelpers.OpenActivityStore(filepath.Join(t.TempDir(), stravahelpers.ActivitiesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	start, end := getYearRange(2021, nil)
+	report := func() stravaDistances {
+		return getStravaDistances(store, periodKind{name: "year"}.split(start, end), periodKind{}, defaultBaseline, nil)[0]
+	}
+
+	err = syncActivities(ctx, client, store, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distances := report(); distances.commute != 18 || distances.pleasure != 20 {
+		t.Errorf("expected 18 km of commuting and 20 km of pleasure, got %+v", distances)
+	}
+
+	// the athlete marks the pleasure ride as a commute and deletes the e-bike ride
+	server.SetActivities(testActivity(3, "Ride", 20, true, time.Date(2021, 6, 1, 16, 0, 0, 0, time.UTC)))
+	server.DeleteActivity(4)
+	err = syncActivities(ctx, client, store, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distances := report(); distances.commute != 30 || distances.pleasure != 0 {
+		t.Errorf("expected 30 km of commuting after the edits, got %+v", distances)
+	}
+
+	// a sync that fails leaves the stored activities as they were
+	server.Fail(stravahelpers.StravaListActivitiesPath, http.StatusInternalServerError, stravahelpers.DefaultRetryPolicy.MaxAttempts)
+	err = syncActivities(ctx, client, store, start)
+	if err == nil {
+		t.Error("expected the sync to fail")
+	}
+	if distances := report(); distances.commute != 30 {
+		t.Errorf("expected the stored activities to be kept, got %+v", distances)
+	}
+}
+
+// TestYearBoundaries checks that rides around New Year are reported in the year they started in
+// locally, in their own timezone or the one asked for.
+func TestYearBoundaries(t *testing.T) {
+	vancouver, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Skip("no timezone database: ", err)
+	}
+	store, err := stravahelpers.OpenActivityStore(filepath.Join(t.TempDir(), stravahelpers.ActivitiesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ride := func(id int64, km float64, commute bool, start, local time.Time) stravahelpers.SummaryActivity {
+		activity := testActivity(id, "Ride", km, commute, start).SummaryActivity
+		activity.StartDateLocal = local
+		return activity
+	}
+	store.Put(
+		// 23:30 on New Year's Eve in Vancouver, already 2021 in UTC
+		ride(1, 10, true, time.Date(2021, 1, 1, 7, 30, 0, 0, time.UTC), time.Date(2020, 12, 31, 23, 30, 0, 0, time.UTC)),
+		// 00:30 on New Year's Day in Sydney, on daylight saving time, still 2020 in UTC
+		ride(2, 20, false, time.Date(2020, 12, 31, 13, 30, 0, 0, time.UTC), time.Date(2021, 1, 1, 0, 30, 0, 0, time.UTC)),
+		// the first morning of the year in Vancouver
+		ride(3, 5, true, time.Date(2021, 1, 1, 14, 0, 0, 0, time.UTC), time.Date(2021, 1, 1, 6, 0, 0, 0, time.UTC)),
+		// the last evening of the year in Vancouver, already 2022 in UTC
+		ride(4, 7, true, time.Date(2022, 1, 1, 4, 0, 0, 0, time.UTC), time.Date(2021, 12, 31, 20, 0, 0, 0, time.UTC)),
+	)
+
+	cases := []struct {
+		loc      *time.Location
+		expected map[int]stravaDistances
+	}{
+		{nil, map[int]stravaDistances{2020: {commute: 10}, 2021: {commute: 12, pleasure: 20}, 2022: {}}},
+		{vancouver, map[int]stravaDistances{2020: {commute: 10, pleasure: 20}, 2021: {commute: 12}, 2022: {}}},
+		{time.UTC, map[int]stravaDistances{2020: {pleasure: 20}, 2021: {commute: 15}, 2022: {commute: 7}}},
+	}
+	for _, c := range cases {
+		start, _ := getYearRange(2020, c.loc)
+		_, end := getYearRange(2022, c.loc)
+		distances := getStravaDistances(store, periodKind{name: "year"}.split(start, end), periodKind{}, defaultBaseline, c.loc)
+		for i, got := range distances {
+			year := 2020 + i
+			if expected := c.expected[year]; got.label != fmt.Sprint(year) || got.commute != expected.commute || got.pleasure != expected.pleasure {
+				t.Errorf("%d in %v: expected %.0f km commuting and %.0f km pleasure, got %.0f and %.0f",
+					year, c.loc, expected.commute, expected.pleasure, got.commute, got.pleasure)
+			}
+		}
+	}
+
+	// an imported ride on the last evening of 2021 in Vancouver, without a local time, is in 2022 in
+	// UTC until the athlete's timezone is known
+	store.Import(ride(5, 9, false, time.Date(2022, 1, 1, 5, 0, 0, 0, time.UTC), time.Time{}))
+	for _, c := range []struct {
+		zone     string
+		in2021Km float64 // of the imported ride, the rest is in 2022
+	}{{"", 0}, {"America/Vancouver", 9}} {
+		if c.zone != "" {
+			store.SetTimezone(c.zone)
+		}
+		start, _ := getYearRange(2021, nil)
+		_, end := getYearRange(2022, nil)
+		distances := getStravaDistances(store, periodKind{name: "year"}.split(start, end), periodKind{}, defaultBaseline, nil)
+		if distances[0].pleasure != 20+c.in2021Km || distances[1].pleasure != 9-c.in2021Km {
+			t.Errorf("in the timezone %q, expected %.0f km of the imported ride in 2021, got %.0f in 2021 and %.0f in 2022",
+				c.zone, c.in2021Km, distances[0].pleasure, distances[1].pleasure)
+		}
+	}
+
+	// a year that starts on daylight saving time is still a whole year long
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+	start, end := getYearRange(2021, sydney)
+	if !start.Equal(time.Date(2020, 12, 31, 13, 0, 0, 0, time.UTC)) || end.Sub(start) != 365*24*time.Hour {
+		t.Errorf("unexpected year in Sydney: %s to %s", start, end)
+	}
+}
+
+// TestBreakdown breaks a year down into months and weeks, and combines the breakdowns of two
+// athletes.
+func TestBreakdown(t *testing.T) {
+	store, err := stravahelpers.OpenActivityStore(filepath.Join(t.TempDir(), stravahelpers.ActivitiesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(
+		testActivity(1, "Ride", 10, true, time.Date(2021, 1, 4, 8, 0, 0, 0, time.UTC)).SummaryActivity,
+		testActivity(2, "Ride", 12, true, time.Date(2021, 1, 10, 8, 0, 0, 0, time.UTC)).SummaryActivity,
+		testActivity(3, "Ride", 30, false, time.Date(2021, 1, 11, 8, 0, 0, 0, time.UTC)).SummaryActivity,
+		testActivity(4, "Run", 5, false, time.Date(2021, 1, 12, 8, 0, 0, 0, time.UTC)).SummaryActivity,
+		testActivity(5, "Ride", 8, true, time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)).SummaryActivity,
+	)
+	start, end := getYearRange(2021, nil)
+	periods := periodKind{name: "year"}.split(start, end)
+
+	months := getStravaDistances(store, periods, periodKind{name: "month"}, defaultBaseline, nil)[0]
+	if len(months.breakdown) != 12 || months.commuteRides != 3 || months.pleasureRides != 1 {
+		t.Fatalf("expected 12 months of 4 rides, got %+v", months)
+	}
+	january, february, march := months.breakdown[0], months.breakdown[1], months.breakdown[2]
+	if january.label != "2021-01" || january.commute != 22 || january.commuteRides != 2 || january.pleasure != 30 || january.pleasureRides != 1 {
+		t.Errorf("unexpected January: %+v", january)
+	}
+	if february.commuteRides+february.pleasureRides != 0 || march.commute != 8 || march.commuteRides != 1 {
+		t.Errorf("unexpected February and March: %+v, %+v", february, march)
+	}
+
+	// the year starts part way through 2020-W53, and the last week is clipped to the end of the year
+	weeks := getStravaDistances(store, periods, periodKind{name: "week"}, defaultBaseline, nil)[0]
+	first, last := weeks.breakdown[0], weeks.breakdown[len(weeks.breakdown)-1]
+	if first.label != "2020-W53" || !first.clipped || weeks.breakdown[1].commute != 22 || weeks.breakdown[2].pleasure != 30 {
+		t.Errorf("unexpected weeks: %+v", weeks.breakdown[:3])
+	}
+	if last.label != "2021-W52" || !last.end.Equal(end) {
+		t.Errorf("unexpected last week: %+v", last)
+	}
+
+	combined := combineDistances([]athleteDistances{{distances: []stravaDistances{months}}, {distances: []stravaDistances{months}}})
+	if january := combined[0].breakdown[0]; combined[0].commuteRides != 6 || january.commute != 44 || january.pleasureRides != 2 {
+		t.Errorf("unexpected combined breakdown: %+v", combined[0])
+	}
+}
+
+// TestEmptyPeriod checks that a period without any rides, such as a week away, is printed with
+// percentages of 0 rather than NaN.
+func TestEmptyPeriod(t *testing.T) {
+	store, err := stravahelpers.OpenActivityStore(filepath.Join(t.TempDir(), stravahelpers.ActivitiesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := getYearRange(2021, nil)
+	kind := periodKind{name: "week"}
+	distances := getStravaDistances(store, kind.split(start, end.AddDate(0, 0, -358)), periodKind{}, defaultBaseline, nil)
+
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = writer
+	outputStravaDistances(distances, kind, periodKind{}, nil)
+	os.Stdout = stdout
+	writer.Close()
+	output, _ := ioutil.ReadAll(reader)
+
+	if len(distances) != 2 || strings.Contains(string(output), "NaN") || !strings.Contains(string(output), "Total Commute (km): 0.0, 0.0%") {
+		t.Errorf("expected the empty weeks to have percentages of 0, got:\n%s", output)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/stravahelpers"
+)
+
+// commuteTimes are the moving and elapsed times of the commutes in a period.
+type commuteTimes struct {
+	moving    time.Duration
+	elapsed   time.Duration
+	stopped   time.Duration   // elapsed time that wasn't moving, of the commutes that have a moving time
+	timed     int             // commutes that have a moving time
+	timedKm   float64         // distance of the commutes that have a moving time, for the average speed
+	durations []time.Duration // elapsed time of each commute, for the median and p90
+}
+
+// add adds the times of a commute. Activities without times, such as some manual entries, only count
+// towards the durations if they have an elapsed time, and towards the speed and stopped time if they
+// have a moving time.
+func (c *commuteTimes) add(activity stravahelpers.SummaryActivity) {
+	moving := time.Duration(activity.MovingTime) * time.Second
+	elapsed := time.Duration(activity.ElapsedTime) * time.Second
+	c.moving += moving
+	c.elapsed += elapsed
+	if moving > 0 {
+		c.timed++
+		c.timedKm += activity.Distance / 1000
+		if elapsed > moving {
+			c.stopped += elapsed - moving
+		}
+	}
+	if elapsed > 0 {
+		c.durations = append(c.durations, elapsed)
+	}
+}
+
+// combine returns the times of the commutes in both c and other.
+func (c commuteTimes) combine(other commuteTimes) commuteTimes {
+	combined := commuteTimes{
+		moving:  c.moving + other.moving,
+		elapsed: c.elapsed + other.elapsed,
+		stopped: c.stopped + other.stopped,
+		timed:   c.timed + other.timed,
+		timedKm: c.timedKm + other.timedKm,
+	}
+	combined.durations = append(append(combined.durations, c.durations...), other.durations...)
+	return combined
+}
+
+// average returns the total divided over the commutes, or 0 if there are none.
+func average(total time.Duration, commutes int) time.Duration {
+	if commutes == 0 {
+		return 0
+	}
+	return total / time.Duration(commutes)
+}
+
+// averageMoving returns the average moving time of the commutes that have one.
+func (c commuteTimes) averageMoving() time.Duration {
+	return average(c.moving, c.timed)
+}
+
+// averageElapsed returns the average elapsed time of the commutes that have one.
+func (c commuteTimes) averageElapsed() time.Duration {
+	return average(c.elapsed, len(c.durations))
+}
+
+// averageStopped returns the average time stopped during the commutes that have a moving time.
+func (c commuteTimes) averageStopped() time.Duration {
+	return average(c.stopped, c.timed)
+}
+
+// speed returns the average moving speed of the commutes in km/h, or 0 if they have no moving time.
+func (c commuteTimes) speed() float64 {
+	if c.moving <= 0 {
+		return 0
+	}
+	return c.timedKm / c.moving.Hours()
+}
+
+// percentile returns the commute duration that p of the commutes take less than, from 0 to 1,
+// interpolating between the two nearest commutes. It returns 0 if there are no durations.
+func (c commuteTimes) percentile(p float64) time.Duration {
+	if len(c.durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), c.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	position := p * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	fraction := position - float64(lower)
+	return sorted[lower] + time.Duration(fraction*float64(sorted[upper]-sorted[lower]))
+}
+
+// formatDuration returns the duration as h:mm:ss, rounded to the second.
+func formatDuration(d time.Duration) string {
+	seconds := int64(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// outputCommuteTimes prints the time statistics of the commutes in a period.
+func outputCommuteTimes(c commuteTimes, commutes int) {
+	fmt.Printf("Commute Time (h:mm:ss): %d commutes\n", commutes)
+	fmt.Printf("  Moving: %s, average %s\n", formatDuration(c.moving), formatDuration(c.averageMoving()))
+	fmt.Printf("  Elapsed: %s, average %s\n", formatDuration(c.elapsed), formatDuration(c.averageElapsed()))
+	fmt.Printf("  Stopped: %s, average %s\n", formatDuration(c.stopped), formatDuration(c.averageStopped()))
+	fmt.Printf("  Elapsed per commute: median %s, p90 %s\n", formatDuration(c.percentile(0.5)), formatDuration(c.percentile(0.9)))
+	fmt.Printf("  Average commute speed (km/h): %.1f\n", c.speed())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestCommuteTimes adds up the times of some commutes, one of them without a moving time.
+func TestCommuteTimes(t *testing.T) {
+	commute := func(km float64, moving, elapsed time.Duration) stravaDistances {
+		d := stravaDistances{}
+		activity := testActivity(1, "Ride", km, true, time.Time{}).SummaryActivity
+		activity.MovingTime = int(moving.Seconds())
+		activity.ElapsedTime = int(elapsed.Seconds())
+		d.times.add(activity)
+		return d
+	}
+	var c commuteTimes
+	for _, d := range []stravaDistances{
+		commute(10, 20*time.Minute, 25*time.Minute),
+		commute(10, 30*time.Minute, 30*time.Minute),
+		commute(10, 25*time.Minute, 40*time.Minute),
+		commute(10, 0, 35*time.Minute), // a manual entry
+	} {
+		c = c.combine(d.times)
+	}
+
+	if c.moving != 75*time.Minute || c.elapsed != 130*time.Minute || c.stopped != 20*time.Minute {
+		t.Errorf("unexpected totals: %+v", c)
+	}
+	if c.averageMoving() != 25*time.Minute || c.averageElapsed() != 32*time.Minute+30*time.Second || c.averageStopped() != 6*time.Minute+40*time.Second {
+		t.Errorf("unexpected averages: %s, %s, %s", c.averageMoving(), c.averageElapsed(), c.averageStopped())
+	}
+	// the manual entry has no moving time, so doesn't count towards the speed or the stopped time
+	if speed := c.speed(); math.Abs(speed-24) > 0.001 {
+		t.Errorf("expected 24 km/h, got %.3f", speed)
+	}
+	// 25, 30, 35 and 40 minutes
+	if median, p90 := c.percentile(0.5), c.percentile(0.9); median != 32*time.Minute+30*time.Second || p90 != 38*time.Minute+30*time.Second {
+		t.Errorf("unexpected median %s and p90 %s", median, p90)
+	}
+	if formatted := formatDuration(c.elapsed); formatted != "2:10:00" {
+		t.Errorf("expected 2:10:00, got %s", formatted)
+	}
+	if empty := (commuteTimes{}); empty.speed() != 0 || empty.percentile(0.9) != 0 || empty.averageMoving() != 0 {
+		t.Errorf("expected no commutes to have no times")
+	}
+}
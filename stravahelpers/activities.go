@@ -6,112 +6,288 @@
 // The first function to call is StravaAuthenticate. This will attempt to authenticate using OAuth
 // with Strava and populate the authentication tokens. If it is not run, the tokens will be empty
 // and attempts to interact with Strava APIs will fail.
+// The package level functions all use a default Client. To work with more than one athlete, or to
+// point at a different server, create a Client with NewClient and call its methods instead.
 package stravahelpers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/droppedbars/strava-commute-times/logger"
 )
 
-const stravaBasePath = "https://www.strava.com/api/v3/"
+// The API paths below are relative to the Client's base URL, which defaults to
+// https://www.strava.com/api/v3/
+
+// StravaGetAthletePath is the URL to GET the authenticated athlete
+const StravaGetAthletePath = "athlete"
 
 // StravaGetActivityPath is the URL for strava's GET activities
-const StravaGetActivityPath = stravaBasePath + "activities/"
+const StravaGetActivityPath = "activities/"
+
+// StravaGetActivityStreamsPath is the URL to GET the streams of an activity. The activity ID must
+// be provided, url := sprintf(StravaGetActivityStreamsPath, "12345")
+const StravaGetActivityStreamsPath = "activities/%d/streams"
 
 // StravaListActivitiesPath is the URL to GET the list of all of an athletes activities
-const StravaListActivitiesPath = stravaBasePath + "athlete/activities/"
+const StravaListActivitiesPath = "athlete/activities/"
 
 // StravaListClubMembersPath is the URL to GET the list of members for a club. The club ID must
 // be provided, url := sprintf(StravaListClubMembersPath, "12345")
-const StravaListClubMembersPath = stravaBasePath + "clubs/%d/members"
+const StravaListClubMembersPath = "clubs/%d/members"
 
 // StravaListClubActivitiesPath is the URL to GET the list of activities for a club. The club ID must
 // be provided, url := sprintf(StravaListClubActivitiesPath, "12345")
-const StravaListClubActivitiesPath = stravaBasePath + "clubs/%d/activities"
+const StravaListClubActivitiesPath = "clubs/%d/activities"
+
+// StravaListAthleteRoutesPath is the URL to GET the list of an athlete's routes. The athlete ID must
+// be provided, url := sprintf(StravaListAthleteRoutesPath, "12345")
+const StravaListAthleteRoutesPath = "athletes/%d/routes"
 
 // StravaGetAtheleteStatsPath is the URL to GET the athlete's stats. The athlete ID must
 // be provided, url := sprintf(StravaGetAtheleteStatsPath, "12345")
-const StravaGetAtheleteStatsPath = stravaBasePath + "athletes/%d/stats"
+const StravaGetAtheleteStatsPath = "athletes/%d/stats"
 
-// StravaAPIGetResponse makes a call to a Strava GET API.
+// StravaAPIGetResponse makes a call to a Strava GET API using the default Client.
 //  url is the URL to the API
-//  params is a map of key/value parameters to provide to the API
-// TODO: params should handle parameters that are not uint64
-func StravaAPIGetResponse(url string, params map[string]uint64) ([]byte, error) {
+//  params are the query parameters to provide to the API
+func StravaAPIGetResponse(url string, params Params) ([]byte, error) {
+	return StravaAPIGetResponseContext(context.Background(), url, params)
+}
+
+// StravaAPIGetResponseContext is StravaAPIGetResponse with a context that cancels the call.
+func StravaAPIGetResponseContext(ctx context.Context, url string, params Params) ([]byte, error) {
+	return defaultClient.GetResponse(ctx, url, params)
+}
+
+// StravaAPIGetJSON returns the Strava API response which is expected to be a json result.
+func StravaAPIGetJSON(url string, params Params) (map[string]interface{}, error) {
+	return StravaAPIGetJSONContext(context.Background(), url, params)
+}
+
+// StravaAPIGetJSONContext is StravaAPIGetJSON with a context that cancels the call.
+func StravaAPIGetJSONContext(ctx context.Context, url string, params Params) (map[string]interface{}, error) {
+	return defaultClient.GetJSON(ctx, url, params)
+}
+
+// StravaAPIGetArray returns the Strava API response which is expected to be an array of json results.
+//  url is the API url, params are the query parameters.
+func StravaAPIGetArray(url string, params Params) ([]map[string]interface{}, error) {
+	return StravaAPIGetArrayContext(context.Background(), url, params)
+}
+
+// StravaAPIGetArrayContext is StravaAPIGetArray with a context that cancels the call.
+func StravaAPIGetArrayContext(ctx context.Context, url string, params Params) ([]map[string]interface{}, error) {
+	return defaultClient.GetArray(ctx, url, params)
+}
+
+// StravaListActivities returns a page of the authenticated athlete's activities. params are the
+// query parameters to provide to the API, such as before, after, page and per_page.
+func StravaListActivities(params Params) ([]SummaryActivity, error) {
+	return StravaListActivitiesContext(context.Background(), params)
+}
+
+// StravaListActivitiesContext is StravaListActivities with a context that cancels the call.
+func StravaListActivitiesContext(ctx context.Context, params Params) ([]SummaryActivity, error) {
+	return defaultClient.ListActivities(ctx, params)
+}
+
+// StravaGetActivity returns the activity with the given id. If the activity does not belong to
+// the authenticated athlete, or it is private and the activity:read_all scope was not granted,
+// Strava responds with a 404 - Not Found.
+func StravaGetActivity(activityID uint64) (DetailedActivity, error) {
+	return StravaGetActivityContext(context.Background(), activityID)
+}
+
+// StravaGetActivityContext is StravaGetActivity with a context that cancels the call.
+func StravaGetActivityContext(ctx context.Context, activityID uint64) (DetailedActivity, error) {
+	return defaultClient.GetActivity(ctx, activityID)
+}
+
+// StravaGetAthleteStats returns the totals of an athlete's activities, see GetAthleteStats.
+func StravaGetAthleteStats(athleteID uint64) (map[string]interface{}, error) {
+	return StravaGetAthleteStatsContext(context.Background(), athleteID)
+}
+
+// StravaGetAthleteStatsContext is StravaGetAthleteStats with a context that cancels the call.
+func StravaGetAthleteStatsContext(ctx context.Context, athleteID uint64) (map[string]interface{}, error) {
+	return defaultClient.GetAthleteStats(ctx, athleteID)
+}
+
+// StravaGetActivityStreams returns the streams of an activity, see GetActivityStreams.
+func StravaGetActivityStreams(activityID uint64, keys ...string) (StreamSet, error) {
+	return StravaGetActivityStreamsContext(context.Background(), activityID, keys...)
+}
+
+// StravaGetActivityStreamsContext is StravaGetActivityStreams with a context that cancels the call.
+func StravaGetActivityStreamsContext(ctx context.Context, activityID uint64, keys ...string) (StreamSet, error) {
+	return defaultClient.GetActivityStreams(ctx, activityID, keys...)
+}
+
+// GetResponse makes a call to a Strava GET API. The call, including any waits for the rate limit
+// or retries, is abandoned when ctx is done.
+//  url is the URL to the API, either absolute or relative to the Client's base URL
+//  params are the query parameters to provide to the API
+func (c *Client) GetResponse(ctx context.Context, url string, params Params) ([]byte, error) {
+	url = c.resolve(url)
 	logger.DEBUG.Println("Base API call URL ", url)
-	accessToken := auth.AccessToken
 
-	client := http.Client{
-		Timeout: time.Duration(5 * time.Second),
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
 	}
-	request, err := http.NewRequest("GET", url, nil)
-	request.Header.Set("Authorization", "Bearer "+accessToken)
+	request.Header.Set("User-Agent", c.userAgent)
 
 	query := request.URL.Query()
 	for key, value := range params {
-		query.Add(key, strconv.FormatUint(value, 10))
+		query.Set(key, value)
 	}
 	request.URL.RawQuery = query.Encode()
 	logger.INFO.Println("Full API call URL ", request.URL.String())
 
-	resp, err := client.Do(request)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to access the activities get: %s", err)
+	for refreshed := false; ; refreshed = true {
+		accessToken, err := c.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+
+		body, err := c.do(request)
+		// a rejected token may have been revoked or expired early, so refresh it and try once more
+		if source, ok := c.tokens.(refreshableTokenSource); ok && IsUnauthorized(err) && !refreshed {
+			err = source.refresh(ctx, accessToken)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		logger.TRACE.Printf("activity body: %s\n", body) // dumps the whole resonse
+		return body, nil
 	}
-	defer resp.Body.Close()
+}
 
-	// ensure a proper response. Anything other than 200 is an error (user or server)
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("HTTP Status not 200: %d - %s", resp.StatusCode, resp.Status)
+// GetJSON returns the Strava API response which is expected to be a json result.
+func (c *Client) GetJSON(ctx context.Context, url string, params Params) (map[string]interface{}, error) {
+	var parsed map[string]interface{}
+	err := c.getInto(ctx, url, params, &parsed)
+	if err != nil {
+		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	return parsed, nil
+}
+
+// GetArray returns the Strava API response which is expected to be an array of json results.
+// TODO: need to ensure it gracefully handles API calls that do not return arrays of json
+func (c *Client) GetArray(ctx context.Context, url string, params Params) ([]map[string]interface{}, error) {
+	var parsed []map[string]interface{}
+	err := c.getInto(ctx, url, params, &parsed)
 	if err != nil {
-		return nil, fmt.Errorf("Error reading body: %s", err)
+		return nil, err
 	}
 
-	logger.TRACE.Printf("activity body: %s\n", body) // dumps the whole resonse
-	return body, nil
+	return parsed, nil
 }
 
-// StravaAPIGetJSON returns the Strava API response which is expected to be a json result.
-// TODO: params should handle parameters that are not uint64
-func StravaAPIGetJSON(url string, params map[string]uint64) (map[string]interface{}, error) {
-	rawResponse, err := StravaAPIGetResponse(url, params)
+// ListActivities returns a page of the authenticated athlete's activities. params are the
+// query parameters to provide to the API, such as before, after, page and per_page.
+func (c *Client) ListActivities(ctx context.Context, params Params) ([]SummaryActivity, error) {
+	err := c.requireScope(ScopeActivityRead, "ListActivities")
 	if err != nil {
 		return nil, err
 	}
 
-	var parsed map[string]interface{}
-	err = json.Unmarshal(rawResponse, &parsed)
+	var activities []SummaryActivity
+	err = c.getInto(ctx, StravaListActivitiesPath, params, &activities)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to parse the response: %s", err)
+		return nil, err
 	}
 
-	return parsed, nil
+	return activities, nil
 }
 
-// StravaAPIGetArray returns the Strava API response which is expected to be an array of json results.
-//  url is the API url, params is the key/value map of paramters, accessToken is the Strava access token.
-// TODO: params should handle parameters that are not uint64
-// TODO: need to ensure it gracefully handles API calls that do not return arrays of json
-func StravaAPIGetArray(url string, params map[string]uint64) ([]map[string]interface{}, error) {
-	rawResponse, err := StravaAPIGetResponse(url, params)
+// GetActivity returns the activity with the given id. If the activity does not belong to
+// the authenticated athlete, or it is private and the activity:read_all scope was not granted,
+// Strava responds with a 404 - Not Found.
+func (c *Client) GetActivity(ctx context.Context, activityID uint64) (DetailedActivity, error) {
+	var activity DetailedActivity
+	err := c.requireScope(ScopeActivityRead, "GetActivity")
+	if err != nil {
+		return activity, err
+	}
+
+	err = c.getInto(ctx, StravaGetActivityPath+strconv.FormatUint(activityID, 10), nil, &activity)
+	if err != nil {
+		return activity, err
+	}
+
+	return activity, nil
+}
+
+// GetActivityStreams returns the streams of an activity, such as StreamTime and StreamLatLng. Only
+// the streams named in keys are requested, along with the distance stream that Strava always includes.
+// Streams the activity doesn't have are left nil.
+func (c *Client) GetActivityStreams(ctx context.Context, activityID uint64, keys ...string) (StreamSet, error) {
+	var streams StreamSet
+	err := c.requireScope(ScopeActivityRead, "GetActivityStreams")
+	if err != nil {
+		return streams, err
+	}
+
+	params := Params{}.List("keys", keys...).Bool("key_by_type", true)
+	err = c.getInto(ctx, fmt.Sprintf(StravaGetActivityStreamsPath, activityID), params, &streams)
+	if err != nil {
+		return streams, err
+	}
+
+	return streams, nil
+}
+
+// GetAthlete returns the authenticated athlete.
+func (c *Client) GetAthlete(ctx context.Context) (SummaryAthlete, error) {
+	var athlete SummaryAthlete
+	err := c.requireScope(ScopeRead, "GetAthlete")
+	if err != nil {
+		return athlete, err
+	}
+
+	err = c.getInto(ctx, StravaGetAthletePath, nil, &athlete)
+	if err != nil {
+		return athlete, err
+	}
+
+	return athlete, nil
+}
+
+// GetAthleteStats returns the totals of an athlete's activities that are visible to Everyone. If the
+// athlete is not the authenticated athlete, Strava responds with a 403 - Forbidden.
+func (c *Client) GetAthleteStats(ctx context.Context, athleteID uint64) (map[string]interface{}, error) {
+	err := c.requireScope(ScopeRead, "GetAthleteStats")
 	if err != nil {
 		return nil, err
 	}
+	return c.GetJSON(ctx, fmt.Sprintf(StravaGetAtheleteStatsPath, athleteID), nil)
+}
+
+// getInto makes a call to a Strava GET API and unmarshals the json response into v.
+func (c *Client) getInto(ctx context.Context, url string, params Params, v interface{}) error {
+	rawResponse, err := c.GetResponse(ctx, url, params)
+	if err != nil {
+		return err
+	}
 
-	var parsed []map[string]interface{}
-	err = json.Unmarshal(rawResponse, &parsed)
+	err = json.Unmarshal(rawResponse, v)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to parse the response: %s", err)
+		return fmt.Errorf("Unable to parse the response: %s", err)
 	}
 
-	return parsed, nil
+	return nil
 }
@@ -0,0 +1,348 @@
+package stravahelpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+// ActivitiesFileName is the name of the activity store within a profile.
+const ActivitiesFileName = "activities.json"
+
+// activityStoreVersion is the version of the activity store file. Files written by another version
+// are not read, they are replaced by a full sync instead.
+const activityStoreVersion = 1
+
+// activityStoreFile is the content of an ActivityStore file.
+type activityStoreFile struct {
+	Version    int
+	AthleteID  int64                     // the athlete the activities belong to, 0 if not known
+	SyncedFrom time.Time                 // activities that started after this have been synced
+	SyncedAt   time.Time                 // when the last sync finished
+	ImportedAt time.Time                 // when activities were last imported
+	Activities map[int64]SummaryActivity // keyed by activity id
+	Imported   map[int64]bool            // ids of the activities that were imported rather than synced
+	Timezone   string                    // IANA timezone of the athlete, empty if it is not known
+}
+
+// ActivityStore keeps an athlete's activities in a json file, so that they only need to be fetched
+// from Strava once. Use SyncActivities to bring it up to date. It is safe to use from multiple
+// goroutines, but not from multiple processes.
+type ActivityStore struct {
+	Path string
+	mu   sync.Mutex
+	data activityStoreFile
+}
+
+// OpenActivityStore reads the activity store from the file. A file that doesn't exist, or was written
+// by another version, gives an empty store that is created when it is saved.
+func OpenActivityStore(path string) (*ActivityStore, error) {
+	store := &ActivityStore{Path: path}
+	store.data = activityStoreFile{Version: activityStoreVersion, Activities: map[int64]SummaryActivity{}, Imported: map[int64]bool{}}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stored activityStoreFile
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %s", path, err)
+	}
+	if stored.Version != activityStoreVersion {
+		logger.WARN.Printf("%s is version %d, not %d, so it will be synced again\n", path, stored.Version, activityStoreVersion)
+		return store, nil
+	}
+	if stored.Activities == nil {
+		stored.Activities = map[int64]SummaryActivity{}
+	}
+	if stored.Imported == nil {
+		stored.Imported = map[int64]bool{}
+	}
+	store.data = stored
+	return store, nil
+}
+
+// Save writes the store to its file, replacing it atomically.
+func (s *ActivityStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, data)
+}
+
+// Put adds activities from Strava's API to the store, replacing any with the same id.
+func (s *ActivityStore) Put(activities ...SummaryActivity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, activity := range activities {
+		s.data.Activities[activity.ID] = activity
+		delete(s.data.Imported, activity.ID)
+	}
+}
+
+// Import adds activities that came from somewhere other than Strava's API, such as an export, to
+// the store, replacing any with the same id, and records when they were imported. Imported
+// activities are not deleted by a sync that doesn't return them, as the API doesn't return private
+// activities without the activity:read_all scope.
+func (s *ActivityStore) Import(activities ...SummaryActivity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, activity := range activities {
+		s.data.Activities[activity.ID] = activity
+		s.data.Imported[activity.ID] = true
+	}
+	s.data.ImportedAt = time.Now()
+}
+
+// Imported returns whether the activity with the id was imported rather than synced from Strava.
+func (s *ActivityStore) Imported(id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Imported[id]
+}
+
+// Delete removes the activities with the given ids from the store.
+func (s *ActivityStore) Delete(ids ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.data.Activities, id)
+		delete(s.data.Imported, id)
+	}
+}
+
+// Len returns the number of activities in the store.
+func (s *ActivityStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data.Activities)
+}
+
+// Activities returns the stored activities that started after start and before end, in the order
+// they started. A zero start or end leaves that side of the range open.
+func (s *ActivityStore) Activities(start, end time.Time) []SummaryActivity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var activities []SummaryActivity
+	for _, activity := range s.data.Activities {
+		if (start.IsZero() || activity.StartDate.After(start)) && (end.IsZero() || activity.StartDate.Before(end)) {
+			activities = append(activities, activity)
+		}
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		if activities[i].StartDate.Equal(activities[j].StartDate) {
+			return activities[i].ID < activities[j].ID
+		}
+		return activities[i].StartDate.Before(activities[j].StartDate)
+	})
+	return activities
+}
+
+// Newest returns the start of the most recent stored activity, or the zero time if there are none.
+func (s *ActivityStore) Newest() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newestLocked(false)
+}
+
+// newestLocked is Newest with mu held. Imported activities are left out when synced is true.
+func (s *ActivityStore) newestLocked(synced bool) time.Time {
+	var newest time.Time
+	for _, activity := range s.data.Activities {
+		if activity.StartDate.After(newest) && !(synced && s.data.Imported[activity.ID]) {
+			newest = activity.StartDate
+		}
+	}
+	return newest
+}
+
+// SyncedAt returns when the store was last synced with Strava, or the zero time if it never was.
+func (s *ActivityStore) SyncedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.SyncedAt
+}
+
+// ImportedAt returns when activities were last imported, or the zero time if they never were.
+func (s *ActivityStore) ImportedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.ImportedAt
+}
+
+// SyncedFrom returns the earliest time the store holds activities from, or the zero time if it
+// holds everything. It means nothing if SyncedAt is zero.
+func (s *ActivityStore) SyncedFrom() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.SyncedFrom
+}
+
+// AthleteID returns the athlete the stored activities belong to, or 0 if it is not known.
+func (s *ActivityStore) AthleteID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.AthleteID
+}
+
+// Timezone returns the IANA timezone of the athlete, which activities that don't say where they were
+// recorded are taken to be in, or "" if it is not known.
+func (s *ActivityStore) Timezone() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Timezone
+}
+
+// Location returns the athlete's Timezone as a location, or nil if it is not known.
+func (s *ActivityStore) Location() *time.Location {
+	name := s.Timezone()
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		logger.WARN.Printf("%s has the unknown timezone %s: %s\n", s.Path, name, err)
+		return nil
+	}
+	return loc
+}
+
+// SetTimezone sets the IANA timezone of the athlete, eg America/Vancouver. It is replaced by the
+// timezone of the newest activity from Strava when the store is synced.
+func (s *ActivityStore) SetTimezone(name string) error {
+	if name == "" {
+		return errors.New("the timezone must be an IANA timezone, eg America/Vancouver")
+	}
+	_, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("the timezone must be an IANA timezone, eg America/Vancouver: %s", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Timezone = name
+	return nil
+}
+
+// SyncActivities brings the store up to date with the athlete's activities on Strava and saves it.
+// Only the activities that started after the newest synced one are fetched, plus those between from and
+// the store's SyncedFrom when from is earlier, so a store that is up to date costs a single call.
+// Activities that were edited or deleted on Strava are picked up if they started within resync of the
+// newest synced activity, a negative resync fetches everything after from again. Imported activities,
+// from an export or from files, are never deleted. A zero from syncs every activity the
+// athlete has. The athlete's Timezone is taken from the newest activity fetched that has one. It
+// returns the number of activities fetched.
+func (c *Client) SyncActivities(ctx context.Context, store *ActivityStore, from time.Time, resync time.Duration) (int, error) {
+	store.mu.Lock()
+	// imported activities, such as from a device that doesn't sync to Strava, may be newer than any on
+	// Strava, so only synced ones say where the sync got to
+	storedAthlete, syncedAt, syncedFrom, newest := store.data.AthleteID, store.data.SyncedAt, store.data.SyncedFrom, store.newestLocked(true)
+	store.mu.Unlock()
+	athleteID := c.AthleteID()
+	if athleteID != 0 && storedAthlete != 0 && athleteID != storedAthlete {
+		return 0, fmt.Errorf("%s holds the activities of athlete %d, not %d", store.Path, storedAthlete, athleteID)
+	}
+
+	var fetched []SummaryActivity
+	fetch := func(after, before time.Time) (map[int64]bool, error) {
+		logger.INFO.Println("Syncing activities after ", after, " and before ", before)
+		params := Params{}
+		if !after.IsZero() {
+			params.After(after)
+		}
+		if !before.IsZero() {
+			params.Before(before)
+		}
+		ids := map[int64]bool{}
+		err := c.ActivityPages(ctx, params, PageOptions{Prefetch: true}, func(page []SummaryActivity) error {
+			fetched = append(fetched, page...)
+			for _, activity := range page {
+				ids[activity.ID] = true
+			}
+			return nil
+		})
+		return ids, err
+	}
+
+	if syncedAt.IsZero() {
+		// never synced, so everything from the start of the range
+		_, err := fetch(from, time.Time{})
+		if err != nil {
+			return 0, err
+		}
+		syncedFrom = from
+	} else {
+		if !syncedFrom.IsZero() && (from.IsZero() || from.Before(syncedFrom)) {
+			// the store doesn't go back far enough
+			_, err := fetch(from, syncedFrom)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		after := newest
+		if after.IsZero() || resync < 0 {
+			after = syncedFrom
+		} else {
+			after = after.Add(-resync)
+			if after.Before(syncedFrom) {
+				after = syncedFrom
+			}
+		}
+		ids, err := fetch(after, time.Time{})
+		if err != nil {
+			return 0, err
+		}
+		// stored activities in the resync window that Strava no longer has were deleted, but imported
+		// ones may just be private activities that the token can't read
+		var deleted []int64
+		for _, activity := range store.Activities(after, time.Time{}) {
+			if activity.ID > 0 && !ids[activity.ID] && !store.Imported(activity.ID) {
+				deleted = append(deleted, activity.ID)
+			}
+		}
+		if len(deleted) > 0 {
+			logger.INFO.Println("Activities deleted from Strava: ", deleted)
+			store.Delete(deleted...)
+		}
+		if !syncedFrom.IsZero() && (from.IsZero() || from.Before(syncedFrom)) {
+			syncedFrom = from
+		}
+	}
+
+	store.Put(fetched...)
+	var zoneStart time.Time
+	zone := ""
+	for _, activity := range fetched {
+		if name := activity.TimezoneName(); name != "" && activity.StartDate.After(zoneStart) {
+			zoneStart, zone = activity.StartDate, name
+		}
+	}
+	store.mu.Lock()
+	if athleteID != 0 {
+		store.data.AthleteID = athleteID
+	}
+	// fetching earlier activities doesn't replace the timezone of later ones
+	if zone != "" && (store.data.Timezone == "" || !zoneStart.Before(newest)) {
+		store.data.Timezone = zone
+	}
+	store.data.SyncedFrom = syncedFrom
+	store.data.SyncedAt = c.now()
+	store.mu.Unlock()
+	logger.INFO.Printf("Synced %d activities into %s\n", len(fetched), store.Path)
+	return len(fetched), store.Save()
+}
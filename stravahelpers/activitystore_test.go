@@ -0,0 +1,168 @@
+package stravahelpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSyncActivities checks that a sync only fetches what the store is missing, picks up activities
+// edited or deleted within the resync window, and that the store is saved.
+func TestSyncActivities(t *testing.T) {
+	var mu sync.Mutex
+	day := func(d int) time.Time { return time.Date(2021, 1, d, 8, 0, 0, 0, time.UTC) }
+	onStrava := map[int64]SummaryActivity{}
+	for id := int64(1); id <= 5; id++ {
+		onStrava[id] = SummaryActivity{ID: id, Type: "Ride", StartDate: day(int(id))}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+		before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+		activities := []SummaryActivity{}
+		if r.URL.Query().Get("page") == "1" {
+			for _, activity := range onStrava {
+				start := activity.StartDate.Unix()
+				if start > after && (before == 0 || start < before) {
+					activities = append(activities, activity)
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(activities)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")))
+	path := filepath.Join(t.TempDir(), ActivitiesFileName)
+	store, err := OpenActivityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)
+	fetched, err := client.SyncActivities(context.Background(), store, from, 0)
+	if err != nil || fetched != 3 || store.Len() != 3 {
+		t.Fatalf("expected 3 activities from the first sync, fetched %d, stored %d: %v", fetched, store.Len(), err)
+	}
+
+	mu.Lock()
+	onStrava[6] = SummaryActivity{ID: 6, Type: "Ride", StartDate: day(6), Timezone: "(GMT-08:00) America/Vancouver"}
+	onStrava[5] = SummaryActivity{ID: 5, Type: "Ride", StartDate: day(5), Commute: true}
+	delete(onStrava, 4)
+	mu.Unlock()
+	_, err = client.SyncActivities(context.Background(), store, from, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activities := store.Activities(time.Time{}, time.Time{})
+	if len(activities) != 3 || activities[0].ID != 3 || !activities[1].Commute || activities[2].ID != 6 {
+		t.Errorf("expected the edit, the deletion and the new activity to be synced: %+v", activities)
+	}
+
+	// going back further only fetches the earlier activities
+	fetched, err = client.SyncActivities(context.Background(), store, time.Time{}, 0)
+	if err != nil || fetched != 2 {
+		t.Errorf("expected the 2 earlier activities to be fetched, got %d: %v", fetched, err)
+	}
+
+	reopened, err := OpenActivityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 5 || !reopened.Newest().Equal(day(6)) || !reopened.SyncedFrom().IsZero() || reopened.SyncedAt().IsZero() ||
+		reopened.Timezone() != "America/Vancouver" {
+		t.Errorf("the store was not saved: %d activities, newest %s, synced from %s at %s, timezone %s",
+			reopened.Len(), reopened.Newest(), reopened.SyncedFrom(), reopened.SyncedAt(), reopened.Timezone())
+	}
+}
+
+// TestSyncKeepsImported checks that an imported activity the API doesn't return, such as a private
+// activity without the activity:read_all scope, isn't deleted by a sync, but one that was synced is.
+func TestSyncKeepsImported(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2021, 1, d, 8, 0, 0, 0, time.UTC) }
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		activities := []SummaryActivity{}
+		if r.URL.Query().Get("page") == "1" {
+			activities = append(activities, SummaryActivity{ID: 1, Type: "Ride", StartDate: day(1)})
+		}
+		json.NewEncoder(w).Encode(activities)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")))
+	path := filepath.Join(t.TempDir(), ActivitiesFileName)
+	store, err := OpenActivityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(SummaryActivity{ID: 2, Type: "Ride", StartDate: day(2)})
+	store.Import(SummaryActivity{ID: 3, Type: "Ride", StartDate: day(3)})
+	_, err = client.SyncActivities(context.Background(), store, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.SyncActivities(context.Background(), store, time.Time{}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenActivityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activities := reopened.Activities(time.Time{}, time.Time{})
+	if len(activities) != 2 || activities[0].ID != 1 || activities[1].ID != 3 || !reopened.Imported(3) || reopened.Imported(1) {
+		t.Errorf("expected the synced activity and the imported one to be kept: %+v", activities)
+	}
+}
+
+// TestSyncAfterNewerImport checks that a file imported from a device that doesn't sync to Strava, which
+// is newer than the last sync, doesn't stop the Strava activities before it from being synced.
+func TestSyncAfterNewerImport(t *testing.T) {
+	var mu sync.Mutex
+	day := func(d int) time.Time { return time.Date(2021, 1, d, 8, 0, 0, 0, time.UTC) }
+	onStrava := []SummaryActivity{{ID: 1, Type: "Ride", StartDate: day(1)}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+		activities := []SummaryActivity{}
+		if r.URL.Query().Get("page") == "1" {
+			for _, activity := range onStrava {
+				if activity.StartDate.Unix() > after {
+					activities = append(activities, activity)
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(activities)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")))
+	store, err := OpenActivityStore(filepath.Join(t.TempDir(), ActivitiesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.SyncActivities(context.Background(), store, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Import(SummaryActivity{ID: -1, Type: "Ride", StartDate: day(5)})
+	mu.Lock()
+	onStrava = append(onStrava, SummaryActivity{ID: 3, Type: "Ride", StartDate: day(3), Timezone: "(GMT-08:00) America/Vancouver"})
+	mu.Unlock()
+	fetched, err := client.SyncActivities(context.Background(), store, time.Time{}, 0)
+	if err != nil || fetched != 1 {
+		t.Fatalf("expected the Strava activity before the import to be fetched, got %d: %v", fetched, err)
+	}
+	if activities := store.Activities(time.Time{}, time.Time{}); len(activities) != 3 || store.Timezone() != "America/Vancouver" {
+		t.Errorf("expected both Strava activities, the imported one and the timezone, got %+v in %q", activities, store.Timezone())
+	}
+}
@@ -0,0 +1,174 @@
+package stravahelpers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+const stravaOAuthAuthorizePath = "authorize"
+const exchangeTokenPath = "/exchange_token"
+
+// pasteRedirectURI is where Strava sends the browser in the paste flow. Nothing listens there, the
+// user copies the URL out of the browser instead.
+const pasteRedirectURI = "http://localhost" + exchangeTokenPath
+
+// authorizedPage is shown in the browser once the loopback flow has captured the authorization code.
+const authorizedPage = `<html><body><h1>strava-commute-times is authorized</h1>
+<p>You can close this window and return to the application.</p></body></html>`
+
+// WithLoopbackAuth makes Authenticate capture the authorization code itself, by listening on the given
+// localhost port for Strava to redirect the browser back to it. A port of 0 picks a free port. Without
+// this option the user pastes the URL from the browser back into the application, which also works on
+// machines without a browser.
+func WithLoopbackAuth(port int) Option {
+	return func(c *Client) {
+		c.loopbackAuth = true
+		c.loopbackPort = port
+	}
+}
+
+// authorize has the user authorize the application in their browser, and returns the query parameters
+// Strava redirected back with, which includes the authorization code.
+func (c *Client) authorize(ctx context.Context, sec secrets) (url.Values, error) {
+	state, err := newState()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.loopbackAuth {
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(c.loopbackPort)))
+		if err == nil {
+			return c.loopbackAuthorization(ctx, sec, listener, state)
+		}
+		logger.WARN.Println("Unable to listen for the OAuth redirect, falling back to pasting the URL: ", err)
+	}
+	return c.pasteAuthorization(sec, state)
+}
+
+// authorizeURL returns the URL the user opens in their browser to authorize the application.
+func (c *Client) authorizeURL(sec secrets, redirectURI, state string) string {
+	query := url.Values{
+		"client_id":       {strconv.Itoa(sec.ClientID)},
+		"response_type":   {"code"},
+		"redirect_uri":    {redirectURI},
+		"approval_prompt": {"force"},
+		"scope":           {joinScopes(c.requestedScopes(sec))},
+		"state":           {state},
+	}
+	return c.oauthURL + stravaOAuthAuthorizePath + "?" + query.Encode()
+}
+
+// pasteAuthorization provides the user with a URL to enter in the web browser, and asks for the
+// resulting URL back.
+func (c *Client) pasteAuthorization(sec secrets, state string) (url.Values, error) {
+	fmt.Fprintf(c.authOut, "Enter the following into your web browser: \n")
+	fmt.Fprintf(c.authOut, "   %s\n", c.authorizeURL(sec, pasteRedirectURI, state))
+
+	fmt.Fprintf(c.authOut, "\nCopy and paste the URL from the browser: ")
+	// need to get them to enter the response URL
+	var responseURLString string
+	fmt.Fscanln(c.authIn, &responseURLString)
+
+	logger.INFO.Println("User entered URL: ", responseURLString)
+
+	// parse out the code from Strava
+	responseURL, err := url.Parse(responseURLString)
+	if err != nil {
+		return nil, err
+	}
+	paramMap, err := url.ParseQuery(responseURL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRedirect(paramMap, state)
+	if err != nil {
+		return nil, fmt.Errorf("%s in the supplied URL: %s", err, responseURLString)
+	}
+	return paramMap, nil
+}
+
+// loopbackAuthorization provides the user with a URL to enter in the web browser, then serves the
+// redirect from Strava on the listener until it receives one with the expected state.
+func (c *Client) loopbackAuthorization(ctx context.Context, sec secrets, listener net.Listener, state string) (url.Values, error) {
+	port := listener.Addr().(*net.TCPAddr).Port
+	redirectURI := fmt.Sprintf("http://localhost:%d%s", port, exchangeTokenPath)
+
+	redirects := make(chan url.Values)
+	failures := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(exchangeTokenPath, func(w http.ResponseWriter, r *http.Request) {
+		paramMap := r.URL.Query()
+		// a redirect without our state did not come from this authorization, so ignore it
+		if paramMap.Get("state") != state {
+			logger.WARN.Println("Ignoring OAuth redirect with an unexpected state: ", r.URL.String())
+			http.Error(w, "unexpected state", http.StatusBadRequest)
+			return
+		}
+		err := checkRedirect(paramMap, state)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			select {
+			case failures <- err:
+			default:
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(authorizedPage))
+		select {
+		case redirects <- paramMap:
+		case <-r.Context().Done():
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	fmt.Fprintf(c.authOut, "Enter the following into your web browser: \n")
+	fmt.Fprintf(c.authOut, "   %s\n", c.authorizeURL(sec, redirectURI, state))
+	fmt.Fprintf(c.authOut, "\nWaiting for Strava to redirect back to %s\n", redirectURI)
+
+	select {
+	case paramMap := <-redirects:
+		logger.INFO.Println("Received the OAuth redirect on ", redirectURI)
+		return paramMap, nil
+	case err := <-failures:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// checkRedirect checks the query parameters Strava redirected back with are for this authorization
+// and contain a code.
+func checkRedirect(paramMap url.Values, state string) error {
+	if paramMap.Get("state") != state {
+		return fmt.Errorf("The state does not match the authorization request")
+	}
+	if paramMap.Get("error") != "" {
+		return fmt.Errorf("The authorization was not granted: %s", paramMap.Get("error"))
+	}
+	if paramMap.Get("code") == "" {
+		return fmt.Errorf("The code key could not be found")
+	}
+	return nil
+}
+
+// newState returns a random value for the OAuth state parameter, which protects against a redirect
+// being forged by another site.
+func newState() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,67 @@
+package stravahelpers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// lineWriter passes each line written to it to a channel, without blocking the writer.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		w <- strings.TrimSpace(line)
+	}
+	return len(p), nil
+}
+
+// TestLoopbackAuthorization follows the authorization URL the way a browser would, and checks that
+// a redirect with the wrong state is rejected and the code from the right one is captured.
+func TestLoopbackAuthorization(t *testing.T) {
+	lines := make(lineWriter, 100)
+	client := NewClient(WithLoopbackAuth(0))
+	client.authOut = lines
+
+	go func() {
+		for line := range lines {
+			if !strings.HasPrefix(line, "https://www.strava.com/oauth/authorize?") {
+				continue
+			}
+			authorizeURL, _ := url.Parse(line)
+			redirectURI := authorizeURL.Query().Get("redirect_uri")
+			state := authorizeURL.Query().Get("state")
+
+			resp, err := http.Get(redirectURI + "?state=forged&code=bad")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected a forged redirect to be rejected, got %s", resp.Status)
+			}
+
+			resp, err = http.Get(redirectURI + "?state=" + state + "&code=good&scope=read,activity:read_all")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expected the redirect to be accepted, got %s", resp.Status)
+			}
+		}
+	}()
+
+	paramMap, err := client.authorize(context.Background(), secrets{ClientID: 1, ClientSecret: "secret"})
+	close(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paramMap.Get("code") != "good" {
+		t.Errorf("expected the code from the valid redirect, got %v", paramMap)
+	}
+}
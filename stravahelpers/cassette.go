@@ -0,0 +1,403 @@
+package stravahelpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+// A cassette is a recording of the calls a Client made to Strava and the responses it got, so that a
+// run can be captured with a Recorder and played back with a Replayer, such as to reproduce a problem
+// in a test. Both are http.RoundTrippers, installed with WithTransport.
+
+// cassetteVersion is the version of the cassette file format. A cassette file is json lines, the
+// Cassette without its interactions on the first line, then an Interaction on each line after it.
+const cassetteVersion = 2
+
+// Redacted replaces the tokens, secrets and authorization codes in a cassette.
+const Redacted = "REDACTED"
+
+// redactedParams are the query parameters and form fields that are replaced with Redacted when they
+// are recorded.
+var redactedParams = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"client_secret": true,
+	"code":          true,
+}
+
+// redactedJSONFields are the fields of json bodies that are replaced with Redacted when they are
+// recorded. Strava faults have a code field too, so only the tokens are redacted.
+var redactedJSONFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+}
+
+// redactedHeaders are the headers that are replaced with Redacted when they are recorded.
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// RecordedRequest is a request in a cassette.
+type RecordedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response in a cassette.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Status     string      `json:"status"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body"`
+}
+
+// Interaction is a request and the response it got, or the error if there was no response, such as
+// when Strava couldn't be reached.
+type Interaction struct {
+	Request  RecordedRequest   `json:"request"`
+	Response *RecordedResponse `json:"response,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Duration time.Duration     `json:"duration"`
+}
+
+// Cassette is the interactions recorded by a Recorder, in the order they were made.
+type Cassette struct {
+	Version      int           `json:"version"`
+	RecordedAt   time.Time     `json:"recorded_at"`
+	Interactions []Interaction `json:"interactions,omitempty"`
+}
+
+// LoadCassette reads a cassette written by a Recorder. The interactions recorded before a run was cut
+// short are read even if the last one was only partly written.
+func LoadCassette(path string) (*Cassette, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	var cassette Cassette
+	err = decoder.Decode(&cassette)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the cassette %s: %s", path, err)
+	}
+	if cassette.Version != cassetteVersion {
+		return nil, fmt.Errorf("cassette %s is version %d, only version %d is supported", path, cassette.Version, cassetteVersion)
+	}
+	for {
+		var interaction Interaction
+		err = decoder.Decode(&interaction)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			logger.WARN.Printf("Cassette %s ends after %d interactions with a partly written one: %s\n", path, len(cassette.Interactions), err)
+			break
+		}
+		cassette.Interactions = append(cassette.Interactions, interaction)
+	}
+	return &cassette, nil
+}
+
+// Recorder is an http.RoundTripper that passes calls on to another RoundTripper and records them in
+// a cassette file, with the tokens, secrets and authorization codes replaced by Redacted. Each call is
+// appended to the file as it is made, so the calls leading up to a crash are kept. Close the Recorder
+// when done with it. A Recorder is safe to use from multiple goroutines.
+type Recorder struct {
+	path       string
+	transport  http.RoundTripper
+	mu         sync.Mutex
+	recordedAt time.Time
+	file       *os.File // opened by the first call
+}
+
+// NewRecorder returns a Recorder that writes the cassette to path, replacing the file if it exists.
+// Calls are made with transport, or http.DefaultTransport if it is nil.
+func NewRecorder(path string, transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{
+		path:       path,
+		transport:  transport,
+		recordedAt: time.Now().UTC(),
+	}
+}
+
+// Close closes the cassette file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// RoundTrip makes the call and records it.
+func (r *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if request.Body != nil {
+		var err error
+		requestBody, err = ioutil.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		request = request.Clone(request.Context())
+		request.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	interaction := Interaction{Request: RecordedRequest{
+		Method:  request.Method,
+		URL:     redactURL(request.URL),
+		Headers: redactHeaders(request.Header),
+		Body:    redactBody(request.Header.Get("Content-Type"), requestBody),
+	}}
+	start := time.Now()
+	resp, err := r.transport.RoundTrip(request)
+	var body []byte
+	if err == nil {
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			resp = nil
+		}
+	}
+	if err == nil {
+		interaction.Response = &RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Headers:    redactHeaders(resp.Header),
+			Body:       redactBody(resp.Header.Get("Content-Type"), body),
+		}
+	} else {
+		interaction.Error = err.Error()
+	}
+	interaction.Duration = time.Since(start)
+
+	saveErr := r.record(interaction)
+	if saveErr != nil {
+		logger.WARN.Println("Unable to save the cassette: ", saveErr)
+	}
+	return resp, err
+}
+
+// record appends the interaction to the cassette file, creating it with the cassette's first line
+// if this is the first call.
+func (r *Recorder) record(interaction Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var lines []byte
+	if r.file == nil {
+		file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		r.file = file
+		lines, err = json.Marshal(Cassette{Version: cassetteVersion, RecordedAt: r.recordedAt})
+		if err != nil {
+			return err
+		}
+		lines = append(lines, '\n')
+	}
+	data, err := json.Marshal(interaction)
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Write(append(append(lines, data...), '\n'))
+	return err
+}
+
+// Replayer is an http.RoundTripper that plays back the responses in a cassette instead of calling
+// Strava. Each call gets the response of the first unplayed interaction with the same method and
+// URL. If there isn't one, it gets the first unplayed interaction with the same method and path, so
+// that calls whose parameters depend on when they are made, such as the after of a sync, still play
+// back. A call that isn't in the cassette, or that has already been played back as many times as it
+// was recorded, gets a 501 Not Implemented, which the Client doesn't retry. A Replayer is safe to use
+// from multiple goroutines.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	played       []bool
+}
+
+// NewReplayer returns a Replayer that plays back the cassette.
+func NewReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{
+		interactions: cassette.Interactions,
+		played:       make([]bool, len(cassette.Interactions)),
+	}
+}
+
+// RoundTrip returns the recorded response to the call, or a 501 Not Implemented if there isn't one.
+func (r *Replayer) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body != nil {
+		request.Body.Close()
+	}
+	interaction, ok := r.next(request.Method, request.URL)
+	if !ok {
+		fault, _ := json.Marshal(APIError{Message: fmt.Sprintf("no recorded interaction for %s %s", request.Method, request.URL.Path)})
+		interaction.Response = &RecordedResponse{StatusCode: http.StatusNotImplemented, Status: "501 Not Implemented",
+			Headers: http.Header{"Content-Type": {"application/json"}}, Body: string(fault)}
+	}
+	if interaction.Response == nil {
+		return nil, errors.New(interaction.Error)
+	}
+
+	recorded := interaction.Response
+	header := recorded.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode:    recorded.StatusCode,
+		Status:        recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       request,
+	}, nil
+}
+
+// next finds the interaction to play back for the call, and marks it as played.
+func (r *Replayer) next(method string, requestURL *url.URL) (Interaction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	redacted := redactURL(requestURL)
+	found := -1
+	for i, interaction := range r.interactions {
+		if r.played[i] || interaction.Request.Method != method {
+			continue
+		}
+		if interaction.Request.URL == redacted {
+			found = i
+			break
+		}
+		recordedURL, err := url.Parse(interaction.Request.URL)
+		if found < 0 && err == nil && recordedURL.Path == requestURL.Path {
+			found = i
+		}
+	}
+	if found < 0 {
+		return Interaction{}, false
+	}
+	r.played[found] = true
+	return r.interactions[found], true
+}
+
+// Remaining returns how many of the recorded interactions have not been played back.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remaining := 0
+	for _, played := range r.played {
+		if !played {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// redactURL returns the URL with the sensitive query parameters redacted.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	if redacted.RawQuery != "" {
+		redacted.RawQuery = redactValues(redacted.Query()).Encode()
+	}
+	return redacted.String()
+}
+
+// redactValues replaces the sensitive values in query parameters or a form.
+func redactValues(values url.Values) url.Values {
+	for key := range values {
+		if redactedParams[key] {
+			values[key] = []string{Redacted}
+		}
+	}
+	return values
+}
+
+// redactHeaders returns a copy of the headers with the sensitive ones redacted.
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range redactedHeaders {
+		if redacted.Get(name) == "" {
+			continue
+		}
+		if name == "Authorization" && strings.HasPrefix(redacted.Get(name), "Bearer ") {
+			redacted.Set(name, "Bearer "+Redacted)
+		} else {
+			redacted.Set(name, Redacted)
+		}
+	}
+	return redacted
+}
+
+// redactBody returns a request or response body with the sensitive form or json fields redacted.
+// Bodies without any are returned unchanged.
+func redactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(body))
+		if err == nil {
+			return redactValues(values).Encode()
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // so that large ids aren't rounded when the body is written back out
+	var parsed interface{}
+	if decoder.Decode(&parsed) != nil || !redactJSON(parsed) {
+		return string(body)
+	}
+	redacted, err := json.Marshal(parsed)
+	if err != nil {
+		return string(body)
+	}
+	return string(redacted)
+}
+
+// redactJSON replaces the sensitive fields anywhere in a parsed json value, and returns true if it
+// found any.
+func redactJSON(value interface{}) bool {
+	found := false
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if _, isString := field.(string); isString && redactedJSONFields[key] {
+				value[key] = Redacted
+				found = true
+			} else if redactJSON(field) {
+				found = true
+			}
+		}
+	case []interface{}:
+		for _, element := range value {
+			if redactJSON(element) {
+				found = true
+			}
+		}
+	}
+	return found
+}
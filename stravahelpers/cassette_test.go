@@ -0,0 +1,102 @@
+package stravahelpers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCassette records a token refresh and some calls, checks the secrets are redacted, then plays
+// them back without the server.
+func TestCassette(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "secret-access-2", "refresh_token": "secret-refresh-2", "expires_at": 1600000000}`))
+		case "/api/v3/athlete/activities/":
+			w.Write([]byte(`[{"id": 9007199254740993, "name": "Ride to work", "commute": true}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Record Not Found", "errors": [{"resource": "Activity", "field": "id", "code": "not found"}]}`))
+		}
+	}))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	sec := secrets{ClientID: 1, ClientSecret: "secret-client"}
+	ctx := context.Background()
+
+	calls := func(transport http.RoundTripper) (Tokens, []SummaryActivity, error) {
+		c := NewClient(WithBaseURL(server.URL+"/api/v3"), WithOAuthURL(server.URL+"/oauth"), WithTransport(transport),
+			WithTokenSource(StaticToken("secret-access-1")), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+		auth, err := c.stravaOAuthCall(ctx, sec, "refresh_token", Tokens{RefreshToken: "secret-refresh-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		activities, err := c.ListActivities(ctx, Params{}.Int("page", 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.GetActivity(ctx, 1)
+		return auth, activities, err
+	}
+
+	recorder := NewRecorder(path, nil)
+	auth, recorded, err := calls(recorder)
+	if !IsNotFound(err) || auth.AccessToken != "secret-access-2" {
+		t.Errorf("unexpected recorded calls: %+v, %v", auth, err)
+	}
+	recorder.Close()
+	server.Close()
+	data, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(data), "secret-") {
+		t.Errorf("the cassette has unredacted secrets: %s", data)
+	}
+
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 3 {
+		t.Fatalf("expected 3 interactions, got %d", len(cassette.Interactions))
+	}
+	replayer := NewReplayer(cassette)
+	auth, replayed, err := calls(replayer)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Errors[0].Code != "not found" {
+		t.Errorf("expected the recorded fault to be played back, got %v", err)
+	}
+	if auth.AccessToken != Redacted || auth.ExpiresAt != 1600000000 {
+		t.Errorf("expected redacted tokens to be played back, got %+v", auth)
+	}
+	if len(replayed) != 1 || replayed[0].ID != recorded[0].ID || replayed[0].ID != 9007199254740993 || !replayed[0].Commute {
+		t.Errorf("expected %+v to be played back, got %+v", recorded, replayed)
+	}
+	if replayer.Remaining() != 0 {
+		t.Errorf("expected every interaction to be played back, %d are left", replayer.Remaining())
+	}
+
+	// a call that wasn't recorded fails without being retried
+	c := NewClient(WithBaseURL(server.URL+"/api/v3"), WithTransport(replayer), WithTokenSource(StaticToken("token")))
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		t.Errorf("expected a call that wasn't recorded not to be retried")
+		return nil
+	}
+	if _, err := c.GetAthlete(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotImplemented ||
+		!strings.Contains(apiErr.Message, "no recorded interaction") {
+		t.Errorf("expected a call that wasn't recorded to fail, got %v", err)
+	}
+
+	// a cassette cut short part way through an interaction keeps the ones before it
+	data, _ = ioutil.ReadFile(path)
+	ioutil.WriteFile(path, data[:len(data)-10], 0600)
+	cassette, err = LoadCassette(path)
+	if err != nil || len(cassette.Interactions) != 2 {
+		t.Errorf("expected the 2 whole interactions, got %v", err)
+	}
+}
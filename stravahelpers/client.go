@@ -0,0 +1,215 @@
+package stravahelpers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBaseURL = "https://www.strava.com/api/v3/"
+const defaultOAuthURL = "https://www.strava.com/oauth/"
+const defaultTimeout = 5 * time.Second
+const defaultUserAgent = "strava-commute-times"
+
+// TokenSource provides the access token used to authorize calls to the Strava APIs.
+type TokenSource interface {
+	AccessToken(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource that always provides the same access token. It is useful for tests
+// and for short lived tokens obtained outside of this package.
+type StaticToken string
+
+// AccessToken returns the static access token.
+func (t StaticToken) AccessToken(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// Client makes calls to the Strava APIs on behalf of a single athlete. A Client is safe to use from
+// multiple goroutines, and reuses connections across calls. Create one with NewClient.
+type Client struct {
+	baseURL     string
+	oauthURL    string
+	httpClient  *http.Client
+	transport   http.RoundTripper
+	timeout     time.Duration
+	tokens      TokenSource
+	userAgent   string
+	secretsFile string
+	tokenStore  TokenStore
+
+	scopes       []Scope
+	loopbackAuth bool
+	loopbackPort int
+	authIn       io.Reader // where the pasted authorization URL is read from
+	authOut      io.Writer // where the authorization instructions are written
+
+	retryPolicy   RetryPolicy
+	ratePolicy    RateLimitPolicy
+	rateThreshold float64
+	rateMu        sync.Mutex
+	rateLimit     RateLimit
+	blockedUntil  time.Time
+
+	now   func() time.Time                           // replaceable clock for tests
+	sleep func(context.Context, time.Duration) error // replaceable sleep for tests
+}
+
+// Option configures a Client created by NewClient.
+type Option func(*Client)
+
+// WithBaseURL sets the URL that relative API paths, such as StravaListActivitiesPath, are resolved
+// against. It defaults to https://www.strava.com/api/v3/.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = withTrailingSlash(baseURL)
+	}
+}
+
+// WithOAuthURL sets the URL of Strava's OAuth endpoints. It defaults to https://www.strava.com/oauth/.
+func WithOAuthURL(oauthURL string) Option {
+	return func(c *Client) {
+		c.oauthURL = withTrailingSlash(oauthURL)
+	}
+}
+
+// WithHTTPClient sets the http.Client used to make calls. The client is copied, so its timeout can be
+// changed by WithTimeout without affecting other users of it.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTransport sets the http.RoundTripper used to make calls.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Client) {
+		c.transport = transport
+	}
+}
+
+// WithTimeout sets the timeout for a single call to Strava. It defaults to 5 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithTokenSource sets where the access token comes from. Authenticate replaces it with the tokens
+// obtained from Strava OAuth.
+func WithTokenSource(tokens TokenSource) Option {
+	return func(c *Client) {
+		c.tokens = tokens
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every call.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithSecretsFile sets the json file that Authenticate reads the API application secrets from.
+// It defaults to ./api_client_secrets.json.
+func WithSecretsFile(path string) Option {
+	return func(c *Client) {
+		c.secretsFile = path
+	}
+}
+
+// WithTokensFile sets the json file that Authenticate reads and stores the athlete's tokens in.
+// It defaults to ./tokens.json. It is the same as WithTokenStore with a FileTokenStore.
+func WithTokensFile(path string) Option {
+	return WithTokenStore(FileTokenStore{Path: path})
+}
+
+// NewClient returns a Client configured by the given options. The Client has no access token until
+// Authenticate is called, or a TokenSource is provided with WithTokenSource.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		baseURL:     defaultBaseURL,
+		oauthURL:    defaultOAuthURL,
+		userAgent:   defaultUserAgent,
+		secretsFile: secretsJSONFileName,
+		tokenStore:  FileTokenStore{Path: tokenJSONFileName},
+		authIn:      os.Stdin,
+		authOut:     os.Stdout,
+
+		retryPolicy:   DefaultRetryPolicy,
+		rateThreshold: defaultRateLimitThreshold,
+		now:           time.Now,
+		sleep:         sleepContext,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	httpClient := &http.Client{Timeout: defaultTimeout}
+	if c.httpClient != nil {
+		copied := *c.httpClient
+		httpClient = &copied
+	}
+	if c.transport != nil {
+		httpClient.Transport = c.transport
+	}
+	if c.timeout != 0 {
+		httpClient.Timeout = c.timeout
+	}
+	c.httpClient = httpClient
+
+	return c
+}
+
+// defaultClient is the Client used by the package level functions, such as StravaAPIGetResponse.
+var defaultClient = NewClient()
+
+// DefaultClient returns the Client used by the package level functions. It is authenticated by
+// StravaAuthenticate.
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+// resolve returns the full URL for an API path. Absolute URLs are returned unchanged, anything else
+// is relative to the base URL.
+func (c *Client) resolve(path string) string {
+	parsed, err := url.Parse(path)
+	if err == nil && parsed.IsAbs() {
+		return path
+	}
+	return c.baseURL + strings.TrimPrefix(path, "/")
+}
+
+// accessToken returns the current access token, or an error if the client has not been authenticated.
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	if c.tokens == nil {
+		return "", fmt.Errorf("no access token: the client has not been authenticated")
+	}
+	return c.tokens.AccessToken(ctx)
+}
+
+// withTrailingSlash ensures the base URL ends in a slash so paths can be appended to it.
+func withTrailingSlash(base string) string {
+	if strings.HasSuffix(base, "/") {
+		return base
+	}
+	return base + "/"
+}
+
+// sleepContext pauses for the duration, returning early with the context's error if it is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
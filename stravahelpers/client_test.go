@@ -0,0 +1,36 @@
+package stravahelpers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestClientsAreIndependent makes calls with two clients pointed at a local server and checks each
+// sends its own access token and the configured user agent.
+func TestClientsAreIndependent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/athlete/activities/" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if r.Header.Get("User-Agent") != "commute-test" {
+			t.Errorf("unexpected user agent: %s", r.Header.Get("User-Agent"))
+		}
+		w.Write([]byte(`[{"id": 1, "name": "` + r.Header.Get("Authorization") + `"}]`))
+	}))
+	defer server.Close()
+
+	alice := NewClient(WithBaseURL(server.URL+"/api/v3"), WithTokenSource(StaticToken("alice")), WithUserAgent("commute-test"))
+	bob := NewClient(WithBaseURL(server.URL+"/api/v3"), WithTokenSource(StaticToken("bob")), WithUserAgent("commute-test"))
+
+	for token, client := range map[string]*Client{"alice": alice, "bob": bob} {
+		activities, err := client.ListActivities(context.Background(), Params{}.Int("page", 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(activities) != 1 || activities[0].Name != "Bearer "+token {
+			t.Errorf("expected the %s token to be sent, got %+v", token, activities)
+		}
+	}
+}
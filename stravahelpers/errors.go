@@ -0,0 +1,90 @@
+package stravahelpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FieldError is a single entry in the errors list of a Strava fault. It identifies the resource and
+// field that caused the fault, and a code describing what was wrong with it.
+type FieldError struct {
+	Resource string `json:"resource"`
+	Field    string `json:"field"`
+	Code     string `json:"code"`
+}
+
+// APIError is returned when Strava responds with a status other than 200. It carries the fault that
+// Strava puts in the response body, {"message": ..., "errors": [...]}, when there is one.
+// Use errors.As to get at it, or IsNotFound, IsUnauthorized, IsForbidden and IsRateLimited to check
+// for the common cases.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Endpoint   string       // method and path of the call that failed, eg GET /api/v3/athlete/activities
+	Message    string       `json:"message"`
+	Errors     []FieldError `json:"errors"`
+}
+
+// newAPIError creates an APIError from a Strava response and its body. A body that isn't a Strava
+// fault is ignored.
+func newAPIError(request *http.Request, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{}
+	if len(body) > 0 && json.Unmarshal(body, apiErr) != nil {
+		apiErr = &APIError{}
+	}
+	apiErr.StatusCode = resp.StatusCode
+	apiErr.Status = resp.Status
+	apiErr.Endpoint = request.Method + " " + request.URL.Path
+	return apiErr
+}
+
+// Error describes the failed call and Strava's fault.
+func (e *APIError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: HTTP Status not 200: %s", e.Endpoint, e.Status)
+	if e.Message != "" {
+		fmt.Fprintf(&sb, ": %s", e.Message)
+	}
+	for _, fieldErr := range e.Errors {
+		fmt.Fprintf(&sb, " (resource %s, field %s: %s)", fieldErr.Resource, fieldErr.Field, fieldErr.Code)
+	}
+	return sb.String()
+}
+
+// Is lets errors.Is(err, ErrRateLimited) match a 429 - Too Many Requests from Strava.
+func (e *APIError) Is(target error) bool {
+	return target == ErrRateLimited && e.StatusCode == http.StatusTooManyRequests
+}
+
+// hasStatus returns true if err is, or wraps, an APIError with the given status code.
+func hasStatus(err error, statusCode int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+// IsNotFound returns true if Strava responded 404 - Not Found. Strava also uses this for resources
+// that exist but are private to another athlete, or need a scope that wasn't granted.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized returns true if Strava responded 401 - Unauthorized, usually because the access
+// token is invalid or has expired.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsForbidden returns true if Strava responded 403 - Forbidden, such as when asking for another
+// athlete's stats.
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsRateLimited returns true if Strava responded 429 - Too Many Requests, or the Client stopped
+// because the rate limit was reached.
+func IsRateLimited(err error) bool {
+	return errors.Is(err, ErrRateLimited)
+}
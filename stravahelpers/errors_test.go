@@ -0,0 +1,45 @@
+package stravahelpers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAPIErrorFault checks that Strava's fault body is parsed into an APIError and the status
+// helpers tell the failures apart.
+func TestAPIErrorFault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/athlete" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Authorization Error", "errors": [{"resource": "Athlete", "field": "access_token", "code": "invalid"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Record Not Found", "errors": [{"resource": "Activity", "field": "id", "code": "invalid"}]}`))
+	}))
+	defer server.Close()
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")))
+
+	_, err := client.GetActivity(context.Background(), 12345)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if apiErr.Message != "Record Not Found" || len(apiErr.Errors) != 1 || apiErr.Errors[0].Resource != "Activity" {
+		t.Errorf("unexpected fault: %+v", apiErr)
+	}
+	if apiErr.Endpoint != "GET /activities/12345" {
+		t.Errorf("unexpected endpoint: %s", apiErr.Endpoint)
+	}
+	if !IsNotFound(err) || IsUnauthorized(err) || IsForbidden(err) || IsRateLimited(err) {
+		t.Errorf("expected only IsNotFound to match: %v", err)
+	}
+
+	_, err = client.GetJSON(context.Background(), "athlete", Params{})
+	if !IsUnauthorized(err) || IsNotFound(err) {
+		t.Errorf("expected only IsUnauthorized to match: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package stravahelpers
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+// ExportActivitiesFileName is the name of the activity list in Strava's "Download your data" archive.
+const ExportActivitiesFileName = "activities.csv"
+
+// exportDateLayouts are the formats the Activity Date column has been seen in, all in UTC.
+var exportDateLayouts = []string{
+	"Jan 2, 2006, 3:04:05 PM",
+	"2 Jan 2006, 15:04:05",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// ImportExport reads the activities from a Strava "Download your data" archive, either the zip file
+// or the directory it was extracted to. Only activities.csv is read, the activity files it refers to
+// are not.
+func ImportExport(exportPath string) ([]SummaryActivity, error) {
+	info, err := os.Stat(exportPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		file, err := os.Open(filepath.Join(exportPath, ExportActivitiesFileName))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return ReadExportActivities(file)
+	}
+
+	archive, err := zip.OpenReader(exportPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %s as a zip file: %s", exportPath, err)
+	}
+	defer archive.Close()
+	// the archive may have been zipped again with a top level directory, so take the shallowest one
+	var found *zip.File
+	for _, file := range archive.File {
+		if path.Base(file.Name) == ExportActivitiesFileName && (found == nil || len(file.Name) < len(found.Name)) {
+			found = file
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s has no %s, is it a Strava export?", exportPath, ExportActivitiesFileName)
+	}
+	reader, err := found.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ReadExportActivities(reader)
+}
+
+// ReadExportActivities reads the activities.csv of a Strava export. It uses the Activity ID, Activity
+// Date, Activity Name, Activity Type, Distance, Elapsed Time, Moving Time, Commute and Activity Gear
+// columns. Newer exports repeat some columns, the repeated Distance is in meters where the first is
+// in kilometers, so the last Distance is used. The gear is only known by name, so it is put in
+// GearName rather than GearID.
+func ReadExportActivities(r io.Reader) ([]SummaryActivity, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // rows with trailing empty columns are sometimes short
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the %s header: %s", ExportActivitiesFileName, err)
+	}
+
+	columns := map[string][]int{}
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		columns[name] = append(columns[name], i)
+	}
+	for _, required := range []string{"Activity ID", "Activity Date", "Activity Type", "Distance"} {
+		if len(columns[required]) == 0 {
+			return nil, fmt.Errorf("%s has no %s column", ExportActivitiesFileName, required)
+		}
+	}
+	distanceInKm := len(columns["Distance"]) == 1
+
+	var records [][]string
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", ExportActivitiesFileName, line, err)
+		}
+		records = append(records, record)
+	}
+	decimal := exportDecimalSeparator(records, columns)
+
+	var activities []SummaryActivity
+	for i, record := range records {
+		line := i + 2
+		// field returns the first, or last, column with the name, or "" if there isn't one
+		field := func(name string, last bool) string {
+			indexes := columns[name]
+			if len(indexes) == 0 {
+				return ""
+			}
+			i := indexes[0]
+			if last {
+				i = indexes[len(indexes)-1]
+			}
+			if i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		activity, err := exportActivity(field, distanceInKm, decimal)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", ExportActivitiesFileName, line, err)
+		}
+		activities = append(activities, activity)
+	}
+	logger.INFO.Printf("Read %d activities from %s\n", len(activities), ExportActivitiesFileName)
+	return activities, nil
+}
+
+// exportActivity converts a row of activities.csv, read by field, into an activity.
+func exportActivity(field func(name string, last bool) string, distanceInKm bool, decimal rune) (SummaryActivity, error) {
+	var activity SummaryActivity
+	var err error
+
+	activity.ID, err = strconv.ParseInt(field("Activity ID", false), 10, 64)
+	if err != nil {
+		return activity, fmt.Errorf("Activity ID is not a number: %s", err)
+	}
+	activity.StartDate, err = parseExportDate(field("Activity Date", false))
+	if err != nil {
+		return activity, err
+	}
+	activity.Name = field("Activity Name", false)
+	// the export uses display names, eg E-Bike Ride, where the API uses EBikeRide
+	activity.Type = strings.NewReplacer(" ", "", "-", "").Replace(field("Activity Type", false))
+	activity.SportType = activity.Type
+	activity.GearName = field("Activity Gear", false)
+
+	activity.Distance, err = parseExportNumber(field("Distance", true), decimal)
+	if err != nil {
+		return activity, fmt.Errorf("Distance: %s", err)
+	}
+	if distanceInKm {
+		activity.Distance *= 1000
+	}
+	elapsed, err := parseExportNumber(field("Elapsed Time", false), decimal)
+	if err != nil {
+		return activity, fmt.Errorf("Elapsed Time: %s", err)
+	}
+	activity.ElapsedTime = int(elapsed)
+	moving, err := parseExportNumber(field("Moving Time", false), decimal)
+	if err != nil {
+		return activity, fmt.Errorf("Moving Time: %s", err)
+	}
+	activity.MovingTime = int(moving)
+
+	switch strings.ToLower(field("Commute", false)) {
+	case "true", "1", "1.0":
+		activity.Commute = true
+	}
+	return activity, nil
+}
+
+// parseExportDate parses the Activity Date column.
+func parseExportDate(value string) (time.Time, error) {
+	for _, layout := range exportDateLayouts {
+		date, err := time.ParseInLocation(layout, value, time.UTC)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Activity Date %q is not in a known format", value)
+}
+
+// exportNumberColumns are the columns of activities.csv that hold numbers.
+var exportNumberColumns = []string{"Distance", "Elapsed Time", "Moving Time"}
+
+// exportDecimalSeparator returns the decimal separator of the numbers in the records, either '.' or
+// ',' depending on the language the export was made in, or 0 if none of the numbers say which.
+func exportDecimalSeparator(records [][]string, columns map[string][]int) rune {
+	for _, record := range records {
+		for _, name := range exportNumberColumns {
+			for _, i := range columns[name] {
+				if i >= len(record) {
+					continue
+				}
+				if decimal := decimalSeparator(strings.TrimSpace(record[i])); decimal != 0 {
+					return decimal
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// decimalSeparator returns the decimal separator of a number, '.' or ',', or 0 if the number doesn't
+// say which. A number with both is 1,002.50 or 1.002,50, a separator that is repeated separates
+// thousands, and a single one separates thousands only when it is followed by three digits, so 3,600
+// could be either.
+func decimalSeparator(value string) rune {
+	comma, point := strings.LastIndex(value, ","), strings.LastIndex(value, ".")
+	switch {
+	case comma >= 0 && point >= 0:
+		if comma > point {
+			return ','
+		}
+		return '.'
+	case comma >= 0:
+		if strings.Count(value, ",") > 1 {
+			return '.'
+		}
+		if len(value)-comma-1 != 3 {
+			return ','
+		}
+	case point >= 0:
+		if strings.Count(value, ".") > 1 {
+			return ','
+		}
+		if len(value)-point-1 != 3 {
+			return '.'
+		}
+	}
+	return 0
+}
+
+// parseExportNumber parses a number that may have thousands separators, using decimal as the decimal
+// separator, eg 1,002.50 with '.' or 1.002,50 with ','. When decimal is 0, because the export
+// doesn't say, the number must say which it uses, except that a single point is taken to be a decimal
+// point. Thousands separators must separate groups of three digits. An empty value is 0.
+func parseExportNumber(value string, decimal rune) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if decimal == 0 {
+		decimal = decimalSeparator(value)
+	}
+	if decimal == 0 {
+		if strings.Contains(value, ",") {
+			return 0, fmt.Errorf("%q could have a thousands separator or a decimal comma", value)
+		}
+		decimal = '.'
+	}
+	thousands := ","
+	if decimal == ',' {
+		thousands = "."
+	}
+
+	whole, fraction := value, ""
+	if i := strings.LastIndex(value, string(decimal)); i >= 0 {
+		whole, fraction = value[:i], value[i+1:]
+	}
+	if strings.Contains(whole, string(decimal)) || strings.Contains(fraction, thousands) {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	if strings.Contains(whole, thousands) {
+		groups := strings.Split(strings.TrimLeft(whole, "+-"), thousands)
+		for i, group := range groups {
+			if (i == 0 && (len(group) == 0 || len(group) > 3)) || (i > 0 && len(group) != 3) {
+				return 0, fmt.Errorf("%q has thousands separators that don't separate thousands", value)
+			}
+		}
+		whole = strings.ReplaceAll(whole, thousands, "")
+	}
+	if fraction != "" || strings.ContainsRune(value, decimal) {
+		whole += "." + fraction
+	}
+	return strconv.ParseFloat(whole, 64)
+}
@@ -0,0 +1,122 @@
+package stravahelpers
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportCSV is the start of an activities.csv from a newer export, which repeats the Elapsed Time,
+// Distance and Commute columns.
+const exportCSV = `Activity ID,Activity Date,Activity Name,Activity Type,Activity Description,Elapsed Time,Distance,Commute,Activity Gear,Filename,Elapsed Time,Moving Time,Distance,Commute
+1234,"Mar 4, 2019, 3:05:06 PM",Ride to work,Ride,,1800,"12.35",true,Commuter,activities/1234.gpx,1800.0,1500.0,12345.6,1.0
+5678,"Dec 31, 2019, 11:59:00 PM",Evening,E-Bike Ride,,3600,"1,002.50",false,,activities/5678.fit.gz,3600.0,3000.0,1002500.0,
+9012,"Jan 1, 2020, 7:00:00 AM",Jog,Run,,900,3.00,false,,,900.0,,3000.0,
+`
+
+// TestReadExportActivities checks that the columns of an export are mapped into activities.
+func TestReadExportActivities(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "export.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(file)
+	writer, _ := archive.Create("export_12345/" + ExportActivitiesFileName)
+	writer.Write([]byte(exportCSV))
+	archive.Close()
+	file.Close()
+
+	activities, err := ImportExport(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 3 {
+		t.Fatalf("expected 3 activities, got %d", len(activities))
+	}
+	commute := activities[0]
+	if commute.ID != 1234 || commute.Distance != 12345.6 || !commute.Commute || commute.ElapsedTime != 1800 ||
+		commute.MovingTime != 1500 || commute.GearName != "Commuter" || !commute.IsRide() {
+		t.Errorf("unexpected commute: %+v", commute)
+	}
+	if !commute.StartDate.Equal(time.Date(2019, 3, 4, 15, 5, 6, 0, time.UTC)) {
+		t.Errorf("unexpected start date: %s", commute.StartDate)
+	}
+	if activities[1].Type != "EBikeRide" || activities[1].Commute || activities[1].Distance != 1002500 {
+		t.Errorf("unexpected e-bike ride: %+v", activities[1])
+	}
+	if activities[2].IsRide() {
+		t.Errorf("a run should not be a ride: %+v", activities[2])
+	}
+
+	// an extracted export with the older single Distance column, in kilometers
+	os.WriteFile(filepath.Join(dir, ExportActivitiesFileName),
+		[]byte("Activity ID,Activity Date,Activity Type,Distance,Commute\n1,2018-06-01 08:00:00,Ride,25.5,true\n"), 0600)
+	activities, err = ImportExport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 || activities[0].Distance != 25500 || !activities[0].Commute {
+		t.Errorf("unexpected activities from the directory: %+v", activities)
+	}
+}
+
+// TestParseExportNumber checks numbers with thousands separators and with a decimal comma, in exports
+// that use either decimal separator and in ones that don't say.
+func TestParseExportNumber(t *testing.T) {
+	cases := []struct {
+		value    string
+		decimal  rune
+		expected float64
+	}{
+		{"", 0, 0},
+		{"12.35", 0, 12.35},
+		{"1,002.50", 0, 1002.5},
+		{"1.002,50", 0, 1002.5},
+		{"1,234,567", 0, 1234567},
+		{"12,5", 0, 12.5},
+		{"3,600", '.', 3600},
+		{"3,600", ',', 3.6},
+		{"3.600", ',', 3600},
+		{"3600", ',', 3600},
+		{"1,002", '.', 1002},
+		{"12,5", ',', 12.5},
+	}
+	for _, c := range cases {
+		number, err := parseExportNumber(c.value, c.decimal)
+		if err != nil || number != c.expected {
+			t.Errorf("%q with %q: expected %g, got %g: %v", c.value, c.decimal, c.expected, number, err)
+		}
+	}
+	for _, c := range []struct {
+		value   string
+		decimal rune
+	}{{"3,600", 0}, {"12,5.0", 0}, {"1,00,000.5", 0}, {"12,5", '.'}, {"1.5,000", ','}} {
+		if number, err := parseExportNumber(c.value, c.decimal); err == nil {
+			t.Errorf("%q with %q: expected an error, got %g", c.value, c.decimal, number)
+		}
+	}
+}
+
+// TestExportDecimalSeparator checks that an ambiguous number is read with the decimal separator of the
+// rest of the export, and is an error when nothing in the export says which it is.
+func TestExportDecimalSeparator(t *testing.T) {
+	header := "Activity ID,Activity Date,Activity Type,Elapsed Time,Distance\n"
+	activities, err := ReadExportActivities(strings.NewReader(header +
+		"1,2018-06-01 08:00:00,Ride,\"3,600\",25.5\n2,2018-06-02 08:00:00,Ride,900,\"1,002\"\n"))
+	if err != nil || len(activities) != 2 || activities[0].ElapsedTime != 3600 || activities[1].Distance != 1002000 {
+		t.Errorf("expected 3600 seconds and 1002 km, got %+v: %v", activities, err)
+	}
+	activities, err = ReadExportActivities(strings.NewReader(header + "1,2018-06-01 08:00:00,Ride,900,\"25,5\"\n2,2018-06-02 08:00:00,Ride,900,\"1,002\"\n"))
+	if err != nil || len(activities) != 2 || activities[0].Distance != 25500 || activities[1].Distance != 1002 {
+		t.Errorf("expected 25.5 km and 1.002 km, got %+v: %v", activities, err)
+	}
+	_, err = ReadExportActivities(strings.NewReader(header + "1,2018-06-01 08:00:00,Ride,\"3,600\",25\n"))
+	if err == nil {
+		t.Errorf("expected an error for 3,600 in an export that doesn't say which decimal separator it uses")
+	}
+}
@@ -0,0 +1,223 @@
+package stravahelpers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// The parts of the FIT protocol that ParseFIT reads. See the FIT SDK at
+// https://developer.garmin.com/fit/protocol/
+const (
+	fitMesgSession = 18
+	fitMesgRecord  = 20
+	fitMesgSport   = 12
+
+	fitFieldTimestamp        = 253
+	fitFieldPositionLat      = 0
+	fitFieldPositionLong     = 1
+	fitFieldAltitude         = 2
+	fitFieldDistance         = 5
+	fitFieldEnhancedAltitude = 78
+	fitFieldSessionSport     = 5
+	fitFieldSportSport       = 0
+
+	// fitEpoch is the FIT timestamp epoch, 1989-12-31T00:00:00Z, in seconds since the unix epoch
+	fitEpoch = 631065600
+	// fitSemicircle converts semicircles to degrees
+	fitSemicircle = 180.0 / (1 << 31)
+)
+
+// fitSports maps the FIT sport enum onto Strava's activity types.
+var fitSports = map[uint64]string{1: "Run", 2: "Ride", 11: "Walk", 17: "Hike", 21: "EBikeRide"}
+
+// fitField is a field in a FIT definition message.
+type fitField struct {
+	num  byte
+	size int
+}
+
+// fitDefinition describes the data messages of a local message type.
+type fitDefinition struct {
+	global    uint16
+	order     binary.ByteOrder
+	fields    []fitField
+	devFields int // total size of the developer fields, which are skipped
+}
+
+// ParseFIT parses a FIT activity file as recorded by Garmin, Wahoo and other devices. It reads the
+// record messages for the track points and the sport from the session. Chained FIT files and the
+// CRC are not checked, only the first file is read.
+func ParseFIT(r io.Reader) (Track, error) {
+	var track Track
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return track, err
+	}
+	if len(data) < 12 || string(data[8:12]) != ".FIT" {
+		return track, fmt.Errorf("not a FIT file")
+	}
+	headerSize := int(data[0])
+	dataSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	if headerSize < 12 || headerSize+dataSize > len(data) {
+		return track, fmt.Errorf("FIT file is truncated")
+	}
+	data = data[headerSize : headerSize+dataSize]
+
+	definitions := map[byte]*fitDefinition{}
+	var lastTimestamp uint32
+	for pos := 0; pos < len(data); {
+		header := data[pos]
+		pos++
+
+		if header&0x80 != 0 { // compressed timestamp header, a data message with a 5 bit time offset
+			definition := definitions[(header>>5)&0x03]
+			if definition == nil {
+				return track, fmt.Errorf("FIT data message without a definition at byte %d", pos)
+			}
+			offset := uint32(header & 0x1f)
+			lastTimestamp += (offset - lastTimestamp&0x1f) & 0x1f
+			values, next, err := definition.read(data, pos)
+			if err != nil {
+				return track, err
+			}
+			pos = next
+			values[fitFieldTimestamp] = uint64(lastTimestamp)
+			track.addFIT(definition.global, values)
+			continue
+		}
+
+		local := header & 0x0f
+		if header&0x40 != 0 { // definition message
+			definition, next, err := readFITDefinition(data, pos, header&0x20 != 0)
+			if err != nil {
+				return track, err
+			}
+			definitions[local] = definition
+			pos = next
+			continue
+		}
+
+		definition := definitions[local]
+		if definition == nil {
+			return track, fmt.Errorf("FIT data message without a definition at byte %d", pos)
+		}
+		values, next, err := definition.read(data, pos)
+		if err != nil {
+			return track, err
+		}
+		pos = next
+		if timestamp, ok := values[fitFieldTimestamp]; ok {
+			lastTimestamp = uint32(timestamp)
+		}
+		track.addFIT(definition.global, values)
+	}
+	return track, nil
+}
+
+// readFITDefinition reads a definition message starting at pos, after its header.
+func readFITDefinition(data []byte, pos int, developer bool) (*fitDefinition, int, error) {
+	if pos+5 > len(data) {
+		return nil, pos, fmt.Errorf("FIT definition message is truncated")
+	}
+	definition := &fitDefinition{order: binary.LittleEndian}
+	if data[pos+1] == 1 {
+		definition.order = binary.BigEndian
+	}
+	definition.global = definition.order.Uint16(data[pos+2 : pos+4])
+	numFields := int(data[pos+4])
+	pos += 5
+	if pos+numFields*3 > len(data) {
+		return nil, pos, fmt.Errorf("FIT definition message is truncated")
+	}
+	for i := 0; i < numFields; i++ {
+		definition.fields = append(definition.fields, fitField{num: data[pos], size: int(data[pos+1])})
+		pos += 3
+	}
+	if developer {
+		if pos >= len(data) {
+			return nil, pos, fmt.Errorf("FIT definition message is truncated")
+		}
+		numDevFields := int(data[pos])
+		pos++
+		if pos+numDevFields*3 > len(data) {
+			return nil, pos, fmt.Errorf("FIT definition message is truncated")
+		}
+		for i := 0; i < numDevFields; i++ {
+			definition.devFields += int(data[pos+1])
+			pos += 3
+		}
+	}
+	return definition, pos, nil
+}
+
+// read reads a data message starting at pos, after its header. It returns the integer fields of
+// 1, 2 or 4 bytes that don't hold the invalid value, keyed by field number.
+func (d *fitDefinition) read(data []byte, pos int) (map[byte]uint64, int, error) {
+	values := map[byte]uint64{}
+	for _, field := range d.fields {
+		if pos+field.size > len(data) {
+			return nil, pos, fmt.Errorf("FIT data message is truncated")
+		}
+		raw := data[pos : pos+field.size]
+		pos += field.size
+		switch field.size {
+		case 1:
+			if raw[0] != 0xff {
+				values[field.num] = uint64(raw[0])
+			}
+		case 2:
+			if value := d.order.Uint16(raw); value != 0xffff {
+				values[field.num] = uint64(value)
+			}
+		case 4:
+			// sint32 positions use 0x7fffffff as invalid, the unsigned fields 0xffffffff
+			if value := d.order.Uint32(raw); value != 0xffffffff && value != 0x7fffffff {
+				values[field.num] = uint64(value)
+			}
+		}
+	}
+	pos += d.devFields
+	if pos > len(data) {
+		return nil, pos, fmt.Errorf("FIT data message is truncated")
+	}
+	return values, pos, nil
+}
+
+// addFIT adds what the track needs from a FIT data message.
+func (t *Track) addFIT(global uint16, values map[byte]uint64) {
+	switch global {
+	case fitMesgRecord:
+		timestamp, ok := values[fitFieldTimestamp]
+		if !ok {
+			return
+		}
+		point := TrackPoint{Time: time.Unix(int64(timestamp)+fitEpoch, 0).UTC()}
+		lat, hasLat := values[fitFieldPositionLat]
+		lng, hasLng := values[fitFieldPositionLong]
+		if hasLat && hasLng {
+			point.Lat = float64(int32(uint32(lat))) * fitSemicircle
+			point.Lng = float64(int32(uint32(lng))) * fitSemicircle
+			point.HasPosition = true
+		}
+		if altitude, ok := values[fitFieldEnhancedAltitude]; ok {
+			point.Elevation, point.HasElevation = float64(altitude)/5-500, true
+		} else if altitude, ok := values[fitFieldAltitude]; ok {
+			point.Elevation, point.HasElevation = float64(altitude)/5-500, true
+		}
+		if distance, ok := values[fitFieldDistance]; ok {
+			point.Distance = float64(distance) / 100
+		}
+		t.Points = append(t.Points, point)
+	case fitMesgSession:
+		if sport, ok := values[fitFieldSessionSport]; ok && t.Type == "" {
+			t.Type = fitSports[sport]
+		}
+	case fitMesgSport:
+		if sport, ok := values[fitFieldSportSport]; ok && t.Type == "" {
+			t.Type = fitSports[sport]
+		}
+	}
+}
@@ -5,3 +5,5 @@ go 1.18
 replace github.com/droppedbars/strava-commute-times/logger => ../logger
 
 require github.com/droppedbars/strava-commute-times/logger v0.0.0-00010101000000-000000000000
+
+require golang.org/x/crypto v0.14.0
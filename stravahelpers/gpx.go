@@ -0,0 +1,66 @@
+package stravahelpers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// gpxFile is the part of a GPX file that ParseGPX reads. Routes and waypoints are ignored, only
+// recorded tracks have times.
+type gpxFile struct {
+	Metadata struct {
+		Name string `xml:"name"`
+	} `xml:"metadata"`
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Lat       float64  `xml:"lat,attr"`
+				Lng       float64  `xml:"lon,attr"`
+				Elevation *float64 `xml:"ele"`
+				Time      string   `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// ParseGPX parses a GPX file. All the tracks and segments in the file are joined into one Track,
+// points without a time are skipped.
+func ParseGPX(r io.Reader) (Track, error) {
+	var track Track
+	var gpx gpxFile
+	err := xml.NewDecoder(r).Decode(&gpx)
+	if err != nil {
+		return track, err
+	}
+
+	track.Name = gpx.Metadata.Name
+	for _, trk := range gpx.Tracks {
+		if track.Name == "" {
+			track.Name = trk.Name
+		}
+		if track.Type == "" {
+			track.Type = sportType(trk.Type)
+		}
+		for _, segment := range trk.Segments {
+			for _, trkpt := range segment.Points {
+				if trkpt.Time == "" {
+					continue
+				}
+				pointTime, err := time.Parse(time.RFC3339, trkpt.Time)
+				if err != nil {
+					return track, fmt.Errorf("track point time: %s", err)
+				}
+				point := TrackPoint{Time: pointTime, Lat: trkpt.Lat, Lng: trkpt.Lng, HasPosition: true}
+				if trkpt.Elevation != nil {
+					point.Elevation, point.HasElevation = *trkpt.Elevation, true
+				}
+				track.Points = append(track.Points, point)
+			}
+		}
+	}
+	return track, nil
+}
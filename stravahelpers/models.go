@@ -0,0 +1,235 @@
+package stravahelpers
+
+import (
+	"strings"
+	"time"
+)
+
+// MetaAthlete is the minimal representation of an athlete that Strava embeds in other resources.
+type MetaAthlete struct {
+	ID int64 `json:"id"`
+}
+
+// SummaryAthlete is an athlete as returned by StravaGetAthletePath.
+type SummaryAthlete struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	City      string `json:"city"`
+	State     string `json:"state"`
+	Country   string `json:"country"`
+	Sex       string `json:"sex"`
+	Premium   bool   `json:"premium"`
+	Summit    bool   `json:"summit"`
+}
+
+// ClubAthlete is a member of a club as returned by StravaListClubMembersPath, and the athlete of a
+// ClubActivity. Strava only gives the first letter of the last name.
+type ClubAthlete struct {
+	Firstname  string `json:"firstname"`
+	Lastname   string `json:"lastname"`
+	Membership string `json:"membership"`
+	Admin      bool   `json:"admin"`
+	Owner      bool   `json:"owner"`
+}
+
+// PolylineMap is the encoded route of an activity. SummaryPolyline is returned by the list
+// endpoints, Polyline is only returned when getting a single activity.
+type PolylineMap struct {
+	ID              string `json:"id"`
+	Polyline        string `json:"polyline"`
+	SummaryPolyline string `json:"summary_polyline"`
+}
+
+// SummaryGear is the summary representation of a bike or a pair of shoes.
+type SummaryGear struct {
+	ID       string  `json:"id"`
+	Primary  bool    `json:"primary"`
+	Name     string  `json:"name"`
+	Distance float64 `json:"distance"`
+}
+
+// SummaryActivity is an activity as returned by Strava's list endpoints, such as
+// StravaListActivitiesPath. Distances are in meters, times are in seconds and speeds are in
+// meters per second. Fields that Strava omits are left at their zero value.
+type SummaryActivity struct {
+	ID                   int64       `json:"id"`
+	ExternalID           string      `json:"external_id"`
+	UploadID             int64       `json:"upload_id"`
+	Athlete              MetaAthlete `json:"athlete"`
+	Name                 string      `json:"name"`
+	Distance             float64     `json:"distance"`
+	MovingTime           int         `json:"moving_time"`
+	ElapsedTime          int         `json:"elapsed_time"`
+	TotalElevationGain   float64     `json:"total_elevation_gain"`
+	ElevHigh             float64     `json:"elev_high"`
+	ElevLow              float64     `json:"elev_low"`
+	Type                 string      `json:"type"`
+	SportType            string      `json:"sport_type"`
+	StartDate            time.Time   `json:"start_date"`
+	StartDateLocal       time.Time   `json:"start_date_local"`
+	Timezone             string      `json:"timezone"`
+	StartLatLng          []float64   `json:"start_latlng"`
+	EndLatLng            []float64   `json:"end_latlng"`
+	AchievementCount     int         `json:"achievement_count"`
+	KudosCount           int         `json:"kudos_count"`
+	CommentCount         int         `json:"comment_count"`
+	AthleteCount         int         `json:"athlete_count"`
+	PhotoCount           int         `json:"photo_count"`
+	TotalPhotoCount      int         `json:"total_photo_count"`
+	Map                  PolylineMap `json:"map"`
+	Trainer              bool        `json:"trainer"`
+	Commute              bool        `json:"commute"`
+	Manual               bool        `json:"manual"`
+	Private              bool        `json:"private"`
+	Flagged              bool        `json:"flagged"`
+	WorkoutType          *int        `json:"workout_type"`
+	GearID               string      `json:"gear_id"`
+	GearName             string      `json:"gear_name,omitempty"` // not sent by Strava, set when imported from an export
+	AverageSpeed         float64     `json:"average_speed"`
+	MaxSpeed             float64     `json:"max_speed"`
+	AverageWatts         float64     `json:"average_watts"`
+	WeightedAverageWatts int         `json:"weighted_average_watts"`
+	Kilojoules           float64     `json:"kilojoules"`
+	DeviceWatts          bool        `json:"device_watts"`
+	HasHeartrate         bool        `json:"has_heartrate"`
+	AverageHeartrate     float64     `json:"average_heartrate"`
+	MaxHeartrate         float64     `json:"max_heartrate"`
+}
+
+// DetailedActivity is an activity as returned by StravaGetActivityPath. It is a SummaryActivity
+// with the additional fields Strava only includes when a single activity is requested.
+type DetailedActivity struct {
+	SummaryActivity
+	Description string       `json:"description"`
+	Calories    float64      `json:"calories"`
+	DeviceName  string       `json:"device_name"`
+	EmbedToken  string       `json:"embed_token"`
+	Gear        *SummaryGear `json:"gear"`
+}
+
+// ClubActivity is an activity as returned by StravaListClubActivitiesPath. Strava leaves out the id
+// and dates of club activities.
+type ClubActivity struct {
+	Athlete            ClubAthlete `json:"athlete"`
+	Name               string      `json:"name"`
+	Distance           float64     `json:"distance"`
+	MovingTime         int         `json:"moving_time"`
+	ElapsedTime        int         `json:"elapsed_time"`
+	TotalElevationGain float64     `json:"total_elevation_gain"`
+	Type               string      `json:"type"`
+	SportType          string      `json:"sport_type"`
+	WorkoutType        *int        `json:"workout_type"`
+}
+
+// Route is a planned route as returned by StravaListAthleteRoutesPath. Type is 1 for a ride and 2 for
+// a run.
+type Route struct {
+	ID                  int64       `json:"id"`
+	IDStr               string      `json:"id_str"`
+	Athlete             MetaAthlete `json:"athlete"`
+	Name                string      `json:"name"`
+	Description         string      `json:"description"`
+	Distance            float64     `json:"distance"`
+	ElevationGain       float64     `json:"elevation_gain"`
+	Type                int         `json:"type"`
+	SubType             int         `json:"sub_type"`
+	Private             bool        `json:"private"`
+	Starred             bool        `json:"starred"`
+	EstimatedMovingTime int         `json:"estimated_moving_time"`
+	CreatedAt           time.Time   `json:"created_at"`
+	UpdatedAt           time.Time   `json:"updated_at"`
+	Map                 PolylineMap `json:"map"`
+}
+
+// The keys of the streams Strava records for an activity, for GetActivityStreams.
+const (
+	StreamTime           = "time"
+	StreamDistance       = "distance"
+	StreamLatLng         = "latlng"
+	StreamAltitude       = "altitude"
+	StreamVelocitySmooth = "velocity_smooth"
+	StreamHeartrate      = "heartrate"
+	StreamCadence        = "cadence"
+	StreamWatts          = "watts"
+	StreamTemp           = "temp"
+	StreamMoving         = "moving"
+	StreamGradeSmooth    = "grade_smooth"
+)
+
+// Stream is one series of samples of an activity, such as its altitude. SeriesType is the stream the
+// samples are taken against, either time or distance.
+type Stream[T any] struct {
+	OriginalSize int    `json:"original_size"`
+	Resolution   string `json:"resolution"`
+	SeriesType   string `json:"series_type"`
+	Data         []T    `json:"data"`
+}
+
+// StreamSet is the streams of an activity as returned by StravaGetActivityStreamsPath with
+// key_by_type. Times are seconds from the start, distances are in meters, speeds are in meters per
+// second, and LatLng is pairs of latitude and longitude.
+type StreamSet struct {
+	Time           *Stream[int]        `json:"time"`
+	Distance       *Stream[float64]    `json:"distance"`
+	LatLng         *Stream[[2]float64] `json:"latlng"`
+	Altitude       *Stream[float64]    `json:"altitude"`
+	VelocitySmooth *Stream[float64]    `json:"velocity_smooth"`
+	Heartrate      *Stream[int]        `json:"heartrate"`
+	Cadence        *Stream[int]        `json:"cadence"`
+	Watts          *Stream[int]        `json:"watts"`
+	Temp           *Stream[int]        `json:"temp"`
+	Moving         *Stream[bool]       `json:"moving"`
+	GradeSmooth    *Stream[float64]    `json:"grade_smooth"`
+}
+
+// IsRide returns true if the activity is a bike ride, either a regular or an e-bike ride.
+func (a SummaryActivity) IsRide() bool {
+	return a.Type == "Ride" || a.Type == "EBikeRide"
+}
+
+// LocalStart returns when the activity started in local time. With a location, that is the start in
+// the location. Without one, it is the athlete's local time where the activity was recorded, taken
+// from StartDateLocal, or from the Timezone if only that is known, or UTC if neither is. These are
+// wall clock times with the location set to UTC, so the local times of activities recorded in
+// different timezones can be compared, but they aren't the instant the activity started.
+func (a SummaryActivity) LocalStart(loc *time.Location) time.Time {
+	return a.LocalStartOr(loc, nil)
+}
+
+// LocalStartOr is LocalStart, except that an activity that doesn't say where it was recorded, such as
+// one from an export or a file, is taken to have been recorded in athleteLoc rather than UTC, when
+// athleteLoc isn't nil.
+func (a SummaryActivity) LocalStartOr(loc, athleteLoc *time.Location) time.Time {
+	if loc != nil {
+		return a.StartDate.In(loc)
+	}
+	if !a.StartDateLocal.IsZero() {
+		return wallClock(a.StartDateLocal)
+	}
+	if name := a.TimezoneName(); name != "" {
+		if zone, err := time.LoadLocation(name); err == nil {
+			return wallClock(a.StartDate.In(zone))
+		}
+	}
+	if athleteLoc != nil {
+		return wallClock(a.StartDate.In(athleteLoc))
+	}
+	return a.StartDate.UTC()
+}
+
+// TimezoneName returns the IANA name of the timezone the activity was recorded in, eg
+// America/Vancouver, or "" if it isn't known.
+func (a SummaryActivity) TimezoneName() string {
+	// Strava gives the timezone as "(GMT-08:00) America/Vancouver"
+	if i := strings.LastIndex(a.Timezone, " "); i >= 0 {
+		return a.Timezone[i+1:]
+	}
+	return ""
+}
+
+// wallClock returns the date and time of t, as read off a clock where it happened, in UTC.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,80 @@
+package stravahelpers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestSummaryActivityMissingFields ensures that an activity with omitted and null fields unmarshals
+// to zero values instead of failing.
+func TestSummaryActivityMissingFields(t *testing.T) {
+	data := []byte(`[{"id": 1234, "name": "Morning Ride", "type": "Ride", "distance": 12345.6,
+		"start_date": "2018-05-02T12:15:09Z", "gear_id": null, "workout_type": null, "start_latlng": []}]`)
+
+	var activities []SummaryActivity
+	err := json.Unmarshal(data, &activities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("expected 1 activity, got %d", len(activities))
+	}
+	activity := activities[0]
+	if activity.ID != 1234 || activity.Distance != 12345.6 || !activity.IsRide() {
+		t.Errorf("unexpected activity: %+v", activity)
+	}
+	if activity.Commute || activity.GearID != "" || activity.WorkoutType != nil {
+		t.Errorf("omitted fields should be zero values: %+v", activity)
+	}
+	if !activity.StartDate.Equal(time.Date(2018, 5, 2, 12, 15, 9, 0, time.UTC)) {
+		t.Errorf("unexpected start date: %s", activity.StartDate)
+	}
+}
+
+// TestLocalStart checks the local start of activities either side of New Year, in a location and in
+// the timezone they were recorded in, including when daylight saving time is in effect.
+func TestLocalStart(t *testing.T) {
+	vancouver, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Skip("no timezone database: ", err)
+	}
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+
+	// 23:30 on New Year's Eve in Vancouver, which is already 2021 in UTC
+	eve := SummaryActivity{StartDate: time.Date(2021, 1, 1, 7, 30, 0, 0, time.UTC),
+		StartDateLocal: time.Date(2020, 12, 31, 23, 30, 0, 0, time.UTC), Timezone: "(GMT-08:00) America/Vancouver"}
+	// 00:30 on New Year's Day in Sydney, on daylight saving time at UTC+11, which is still 2020 in UTC
+	newYear := SummaryActivity{StartDate: time.Date(2020, 12, 31, 13, 30, 0, 0, time.UTC)}
+	// 03:30 just after the clocks went forward in Vancouver, known only by the timezone
+	springForward := SummaryActivity{StartDate: time.Date(2021, 3, 14, 10, 30, 0, 0, time.UTC), Timezone: "(GMT-08:00) America/Vancouver"}
+
+	cases := []struct {
+		activity SummaryActivity
+		loc      *time.Location
+		expected time.Time
+	}{
+		{eve, nil, time.Date(2020, 12, 31, 23, 30, 0, 0, time.UTC)},
+		{eve, time.UTC, time.Date(2021, 1, 1, 7, 30, 0, 0, time.UTC)},
+		{eve, sydney, time.Date(2021, 1, 1, 18, 30, 0, 0, sydney)},
+		{newYear, nil, time.Date(2020, 12, 31, 13, 30, 0, 0, time.UTC)},
+		{newYear, sydney, time.Date(2021, 1, 1, 0, 30, 0, 0, sydney)},
+		{springForward, nil, time.Date(2021, 3, 14, 3, 30, 0, 0, time.UTC)},
+		{springForward, vancouver, time.Date(2021, 3, 14, 3, 30, 0, 0, vancouver)},
+	}
+	for _, c := range cases {
+		local := c.activity.LocalStart(c.loc)
+		if !local.Equal(c.expected) || local.Year() != c.expected.Year() || local.Hour() != c.expected.Hour() {
+			t.Errorf("expected %s in %v to start at %s, got %s", c.activity.StartDate, c.loc, c.expected, local)
+		}
+	}
+
+	// an activity from an export, without a local time, is in the athlete's timezone when it is known,
+	// but an activity that says where it was recorded is not
+	if local := newYear.LocalStartOr(nil, sydney); !local.Equal(time.Date(2021, 1, 1, 0, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected the activity to start at 00:30 on New Year's Day in the athlete's timezone, got %s", local)
+	}
+	if local := eve.LocalStartOr(nil, sydney); !local.Equal(time.Date(2020, 12, 31, 23, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected the activity to start in its own timezone, got %s", local)
+	}
+}
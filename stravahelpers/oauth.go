@@ -1,115 +1,175 @@
 package stravahelpers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/droppedbars/strava-commute-times/logger"
 )
 
 const tokenJSONFileName = "./tokens.json"
 const secretsJSONFileName = "./api_client_secrets.json"
-const stravaOAuthPath = "https://www.strava.com/oauth/token"
+const stravaOAuthTokenPath = "token"
+const stravaOAuthDeauthorizePath = "deauthorize"
+
+// The environment variables that can provide the API application secrets instead of the secrets file.
+const (
+	EnvClientID     = "STRAVA_CLIENT_ID"
+	EnvClientSecret = "STRAVA_CLIENT_SECRET"
+)
 
 // secrets struct that contains the secrets for the API application
 type secrets struct {
 	ClientID     int
 	ClientSecret string
+	Scopes       []Scope // optional, the scopes the application requests
 }
 
-// tokens struct that defines the necessary authorization tokens for Strava
-type tokens struct {
-	AuthCode     string
-	RefreshToken string
-	AccessToken  string
+// expiresWithin returns true if the access token expires within d of now. Tokens stored before the
+// expiry was recorded are treated as expired.
+func (t Tokens) expiresWithin(now time.Time, d time.Duration) bool {
+	return t.AccessToken == "" || now.Add(d).Unix() >= t.ExpiresAt
 }
 
-var auth tokens
-var sec secrets
-
-// loadTokens loads the authentication tokens by trying the tokens.json first. If that fails, then it will
-// provide the user with a URL to enter in the web browser, and ask for the resulting URL back,
-// then parses out the authorization code and makes an OAuth call to get a valid refresh and
-// access token.
-// Returns refreshToken, accessToken, error
-func loadTokens(sec secrets) (string, string, error) {
-	var obj tokens
-	var refreshToken string
-	var accessToken string
+// oauthResponse is the body of a successful call to Strava's OAuth token endpoint. The athlete is
+// only included when exchanging an authorization code.
+type oauthResponse struct {
+	AccessToken  string       `json:"access_token"`
+	RefreshToken string       `json:"refresh_token"`
+	ExpiresAt    int64        `json:"expires_at"`
+	Athlete      *MetaAthlete `json:"athlete"`
+}
 
-	if sec.ClientID == 0 || sec.ClientSecret == "" {
-		return refreshToken, accessToken, fmt.Errorf("loadTokens must have non-nil secrets")
-	}
+// refreshMargin is how long before the access token expires that it is refreshed, so that a call
+// started just before the expiry doesn't fail.
+const refreshMargin = 5 * time.Minute
 
-	fileInfo, err := os.Stat(tokenJSONFileName)
-	if (err == nil) && !(fileInfo.IsDir()) { // file exists and is not a directory, so read the auth tokens
-		data, err := ioutil.ReadFile(tokenJSONFileName)
-		if err != nil {
-			return refreshToken, accessToken, err
-		}
+// refreshableTokenSource is a TokenSource that can get a new access token when Strava rejects the
+// current one.
+type refreshableTokenSource interface {
+	TokenSource
+	refresh(ctx context.Context, rejected string) error
+}
 
-		logger.DEBUG.Println("auth tokens raw data from file: ", data)
+// oauthTokenSource is the TokenSource installed by Authenticate. It holds the tokens obtained from
+// Strava OAuth for a single athlete, refreshes them before they expire and stores the rotated tokens.
+// It is safe to use from multiple goroutines, only one of which will refresh the tokens.
+type oauthTokenSource struct {
+	client *Client
+	mu     sync.Mutex
+	sec    secrets
+	auth   Tokens
+}
 
-		err = json.Unmarshal(data, &obj)
+// AccessToken returns the OAuth access token, refreshing it first if it is about to expire.
+func (s *oauthTokenSource) AccessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.auth.expiresWithin(s.client.now(), refreshMargin) {
+		logger.INFO.Println("Access token expires at ", time.Unix(s.auth.ExpiresAt, 0), ", refreshing it")
+		err := s.refreshLocked(ctx)
 		if err != nil {
-			return refreshToken, accessToken, err
+			return "", err
 		}
-	} else { // the auth tokens are missing, so we need to get them from the user
-		fmt.Printf("Enter the following into your web browser: \n")
-		// TODO make the scopes configurable
-		fmt.Printf("   http://www.strava.com/oauth/authorize?client_id=%d&response_type=code&redirect_uri=http://localhost/exchange_token&approval_prompt=force&scope=activity:read_all\n", sec.ClientID)
+	}
+	return s.auth.AccessToken, nil
+}
 
-		fmt.Printf("\nCopy and paste the URL from the browser: ")
-		// need to get them to enter the response URL
-		var responseURLString string
-		fmt.Scanln(&responseURLString)
+// refresh gets a new access token after Strava rejected the given one. If another goroutine has
+// already replaced the rejected token, nothing is done.
+func (s *oauthTokenSource) refresh(ctx context.Context, rejected string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.auth.AccessToken != rejected {
+		return nil
+	}
+	logger.INFO.Println("Access token was rejected by Strava, refreshing it")
+	return s.refreshLocked(ctx)
+}
+
+// refreshLocked makes the OAuth refresh call and stores the new tokens. mu must be held.
+func (s *oauthTokenSource) refreshLocked(ctx context.Context) error {
+	auth, err := s.client.stravaOAuthCall(ctx, s.sec, "refresh_token", s.auth)
+	if err != nil {
+		return err
+	}
+	s.auth = auth
+	return s.client.storeTokens(auth)
+}
 
-		logger.INFO.Println("User entered URL: ", responseURLString)
+// loadTokens loads the authentication tokens by trying the TokenStore first. If there are none, then it will
+// have the user authorize the application in the web browser, either pasting the resulting URL back or
+// having it captured by WithLoopbackAuth, then makes an OAuth call with the authorization code to get a
+// valid refresh and access token, which are stored.
+func (c *Client) loadTokens(ctx context.Context, sec secrets) (Tokens, error) {
+	if sec.ClientID == 0 || sec.ClientSecret == "" {
+		return Tokens{}, fmt.Errorf("loadTokens must have non-nil secrets")
+	}
 
-		// parse out the code from Strava
-		responseURL, err := url.Parse(responseURLString)
+	obj, err := c.tokenStore.Load()
+	if errors.Is(err, ErrNoTokens) { // the auth tokens are missing, so we need to get them from the user
+		paramMap, err := c.authorize(ctx, sec)
 		if err != nil {
-			return refreshToken, accessToken, err
-		}
-		paramMap, err := url.ParseQuery(responseURL.RawQuery)
-		if err != nil {
-			return refreshToken, accessToken, err
-		}
-		code, codeExists := paramMap["code"]
-		if !codeExists {
-			return refreshToken, accessToken, fmt.Errorf("The code key could not be found in the supplied URL: %s", responseURLString)
+			return obj, err
 		}
-		obj.AuthCode = code[0]
+		obj.AuthCode = paramMap.Get("code")
 		logger.DEBUG.Println("Auth code is: ", obj.AuthCode)
+		obj.Scopes = parseGrantedScopes(paramMap.Get("scope"))
+		logger.INFO.Println("Granted scopes: ", joinScopes(obj.Scopes))
 
 		// make a call to OAuth to authenticate and get the refresh token
-		obj, err = stravaOAuthCall(sec, "authorization_code", obj)
+		obj, err = c.stravaOAuthCall(ctx, sec, "authorization_code", obj)
+		if err != nil {
+			return obj, err
+		}
+		err = c.storeTokens(obj)
+		if err != nil {
+			return obj, err
+		}
+	} else if err != nil {
+		return obj, err
 	}
 
-	refreshToken = obj.RefreshToken
-	accessToken = obj.AccessToken
-	logger.DEBUG.Println("refreshToken: ", refreshToken)
-	logger.DEBUG.Println("accessToken: ", accessToken)
+	logger.DEBUG.Println("refreshToken: ", obj.RefreshToken)
+	logger.DEBUG.Println("accessToken: ", obj.AccessToken)
+	logger.DEBUG.Println("expiresAt: ", time.Unix(obj.ExpiresAt, 0))
 
-	return refreshToken, accessToken, nil
+	return obj, nil
 }
 
 // loadSecrets loads the Strava client id, secret and refresh token from the json file
-// and return them in a tokens struct.
-func loadSecrets() (secrets, error) {
+// and return them in a tokens struct. If STRAVA_CLIENT_ID and STRAVA_CLIENT_SECRET are set in the
+// environment they are used instead of the file.
+func (c *Client) loadSecrets() (secrets, error) {
 	var obj secrets
 
-	fileInfo, err := os.Stat(secretsJSONFileName)
+	if id, secret := os.Getenv(EnvClientID), os.Getenv(EnvClientSecret); id != "" && secret != "" {
+		clientID, err := strconv.Atoi(id)
+		if err != nil {
+			return obj, fmt.Errorf("%s must be a number: %s", EnvClientID, err)
+		}
+		logger.DEBUG.Println("Using the client id and secret from the environment")
+		obj.ClientID = clientID
+		obj.ClientSecret = secret
+		return obj, nil
+	}
+
+	fileInfo, err := os.Stat(c.secretsFile)
 	if err != nil || fileInfo.IsDir() {
 		return obj, err
 	}
 
-	data, err := ioutil.ReadFile(secretsJSONFileName)
+	data, err := ioutil.ReadFile(c.secretsFile)
 	if err != nil {
 		return obj, err
 	}
@@ -130,18 +190,11 @@ func loadSecrets() (secrets, error) {
 	return obj, nil
 }
 
-// StoreTokens receives a token struct and stores them in a json file.
-func storeTokens(auth tokens) error {
-	data, err := json.Marshal(auth)
+// storeTokens receives a token struct and saves them in the TokenStore.
+func (c *Client) storeTokens(auth Tokens) error {
+	err := c.tokenStore.Save(auth)
 	if err != nil {
-		return err
-	}
-
-	logger.DEBUG.Println("data to write to json: ", data)
-
-	ioutil.WriteFile(tokenJSONFileName, data, 0644)
-	if err != nil {
-		return err
+		return fmt.Errorf("Unable to store the tokens: %s", err)
 	}
 
 	return nil
@@ -150,7 +203,7 @@ func storeTokens(auth tokens) error {
 // stravaOAuthCall calls the Strava's OAuth APIs. Grant type can be either "refresh_token"
 // or it can be "authorization_code". The values will be set appropriately when
 // making the call to Strava
-func stravaOAuthCall(sec secrets, grantType string, auth tokens) (tokens, error) {
+func (c *Client) stravaOAuthCall(ctx context.Context, sec secrets, grantType string, auth Tokens) (Tokens, error) {
 	var formData map[string][]string
 	if grantType == "refresh_token" {
 		formData = url.Values{
@@ -171,7 +224,13 @@ func stravaOAuthCall(sec secrets, grantType string, auth tokens) (tokens, error)
 	}
 
 	// execute an HTTP POST to Strava OAuth to get new tokens
-	resp, err := http.PostForm(stravaOAuthPath, formData)
+	request, err := http.NewRequestWithContext(ctx, "POST", c.oauthURL+stravaOAuthTokenPath, strings.NewReader(url.Values(formData).Encode()))
+	if err != nil {
+		return auth, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("User-Agent", c.userAgent)
+	resp, err := c.httpClient.Do(request)
 	if err != nil {
 		return auth, err
 	}
@@ -186,50 +245,177 @@ func stravaOAuthCall(sec secrets, grantType string, auth tokens) (tokens, error)
 
 	// ensure a proper response. Anything other than 200 is an error (user or server)
 	if resp.StatusCode != 200 {
-		return auth, fmt.Errorf("HTTP Status not 200: %d - %s", resp.StatusCode, resp.Status)
+		return auth, newAPIError(request, resp, body)
 	}
 	logger.DEBUG.Printf("OAuth http response: %s\n", string(body))
 
-	var parsed map[string]interface{}
+	var parsed oauthResponse
 	err = json.Unmarshal(body, &parsed)
 	if err != nil {
 		return auth, err
 	}
+	if parsed.AccessToken == "" || parsed.RefreshToken == "" {
+		return auth, fmt.Errorf("OAuth response is missing the access or refresh token")
+	}
 
 	// update the token struct with the new refresh token from Strava OAuth request
-	auth.RefreshToken = parsed["refresh_token"].(string)
-	auth.AccessToken = parsed["access_token"].(string)
+	auth.RefreshToken = parsed.RefreshToken
+	auth.AccessToken = parsed.AccessToken
+	auth.ExpiresAt = parsed.ExpiresAt
+	if parsed.Athlete != nil {
+		auth.AthleteID = parsed.Athlete.ID
+	}
 
 	logger.DEBUG.Println("parsed body from OAuth call: ", auth)
 
 	return auth, nil
 }
 
-// StravaAuthenticate attempts to authenticate using OAuth. If there are no access tokens stored
+// StravaAuthenticate attempts to authenticate the default Client using OAuth. If there are no access tokens stored
 // in ./tokens.json then it uses the secrets defiend in ./api_client_secrets.json to make OAuth calls
 // to Strava. It will provide the URL to put into the web browser, in which the user will then authorize
 // the application to have access to Strava. The resulting URL returned from Strava is then pasted
 // back into the application for it to read the access and refresh tokens.
+// The access token is refreshed shortly before it expires, or if Strava rejects it, and the rotated
+// tokens are stored back in ./tokens.json.
 func StravaAuthenticate() error {
-	var err error
+	return StravaAuthenticateContext(context.Background())
+}
 
-	sec, err = loadSecrets()
+// StravaAuthenticateContext is StravaAuthenticate with a context that cancels the OAuth calls.
+func StravaAuthenticateContext(ctx context.Context) error {
+	return defaultClient.Authenticate(ctx)
+}
+
+// Authenticate attempts to authenticate the Client using OAuth, in the same way as StravaAuthenticate,
+// but using the secrets and tokens files the Client was configured with.
+func (c *Client) Authenticate(ctx context.Context) error {
+	sec, err := c.loadSecrets()
+	if err != nil {
+		return err
+	}
+	auth, err := c.loadTokens(ctx, sec)
 	if err != nil {
 		return err
 	}
-	refreshToken, accessToken, err := loadTokens(sec)
-	auth.RefreshToken = refreshToken
-	auth.AccessToken = accessToken
+	if missing := missingScopes(c.requestedScopes(sec), auth.Scopes); auth.Scopes != nil && len(missing) > 0 {
+		logger.WARN.Println("Requested scopes were not granted: ", joinScopes(missing))
+		fmt.Fprintf(c.authOut, "The athlete did not grant the %s scopes, anything that needs them will fail\n", joinScopes(missing))
+	}
 
+	source := &oauthTokenSource{client: c, sec: sec, auth: auth}
+	// refresh now if needed, rather than on the first call, so that problems with the tokens are
+	// reported straight away
+	_, err = source.AccessToken(ctx)
 	if err != nil {
 		return err
 	}
 
-	auth, err = stravaOAuthCall(sec, "refresh_token", auth)
-	err = storeTokens(auth)
+	c.tokens = source
+
+	// tokens stored before the athlete was recorded get it filled in, so profiles can be told apart
+	if auth.AthleteID == 0 {
+		athlete, err := c.GetAthlete(ctx)
+		if err != nil {
+			logger.WARN.Println("Unable to get the authenticated athlete: ", err)
+			return nil
+		}
+		source.mu.Lock()
+		source.auth.AthleteID = athlete.ID
+		err = c.storeTokens(source.auth)
+		source.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AthleteID returns the id of the athlete that authorized the Client, or 0 if it is not known.
+func (c *Client) AthleteID() int64 {
+	source, ok := c.tokens.(*oauthTokenSource)
+	if !ok {
+		return 0
+	}
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	return source.auth.AthleteID
+}
+
+// StravaDeauthorize revokes the default Client's access to the athlete's Strava data, and deletes the
+// stored tokens.
+func StravaDeauthorize() error {
+	return StravaDeauthorizeContext(context.Background())
+}
+
+// StravaDeauthorizeContext is StravaDeauthorize with a context that cancels the OAuth calls.
+func StravaDeauthorizeContext(ctx context.Context) error {
+	return defaultClient.Deauthorize(ctx)
+}
+
+// Deauthorize revokes the Client's access to the athlete's Strava data and deletes the tokens from the
+// TokenStore, so the athlete must authorize the application again before it can be used. The Client
+// does not need to have been authenticated, but ErrNoTokens is returned if no tokens are stored.
+// Tokens that Strava already rejects, because the athlete revoked access on the Strava website, are
+// deleted all the same. If Strava can't be reached the tokens are kept so it can be tried again.
+func (c *Client) Deauthorize(ctx context.Context) error {
+	source, ok := c.tokens.(*oauthTokenSource)
+	if !ok {
+		sec, err := c.loadSecrets()
+		if err != nil {
+			return err
+		}
+		// the store is read directly, since loadTokens would ask the athlete to authorize
+		auth, err := c.tokenStore.Load()
+		if err != nil {
+			return err
+		}
+		source = &oauthTokenSource{client: c, sec: sec, auth: auth}
+	}
+
+	accessToken, err := source.AccessToken(ctx)
+	if err == nil {
+		err = c.stravaDeauthorizeCall(ctx, accessToken)
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnauthorized) {
+		logger.WARN.Println("Strava rejected the tokens, access was already revoked: ", err)
+	} else if err != nil {
+		return err
+	}
+
+	c.tokens = nil
+	err = c.tokenStore.Delete()
+	if err != nil {
+		return fmt.Errorf("Access was revoked, but unable to delete the tokens: %s", err)
+	}
+	logger.INFO.Println("Deauthorized the application and deleted the tokens")
+	return nil
+}
+
+// stravaDeauthorizeCall calls Strava's OAuth deauthorize API, which revokes the access token along
+// with the refresh token and every other access token issued to the application for the athlete.
+func (c *Client) stravaDeauthorizeCall(ctx context.Context, accessToken string) error {
+	formData := url.Values{"access_token": {accessToken}}
+	request, err := http.NewRequestWithContext(ctx, "POST", c.oauthURL+stravaOAuthDeauthorizePath, strings.NewReader(formData.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("User-Agent", c.userAgent)
+	resp, err := c.httpClient.Do(request)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return newAPIError(request, resp, body)
+	}
+	logger.DEBUG.Printf("Deauthorize http response: %s\n", string(body))
 	return nil
 }
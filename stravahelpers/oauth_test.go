@@ -1,14 +1,24 @@
 package stravahelpers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 // TestLoadTokens tests that the loadTokens function fails if the input secrets has values that are defaults.
 func TestLoadTokens(t *testing.T) {
 	var sec secrets
 
-	_, _, err := loadTokens(sec)
+	_, err := NewClient().loadTokens(context.Background(), sec)
 	if err == nil {
 		t.Error(`loadTokens did not return error on newly initialized input`)
 	}
@@ -17,9 +27,125 @@ func TestLoadTokens(t *testing.T) {
 // TestAPICallWithBlankTokens makes a call for a Strava Activity but does not initialize any of the
 // auth tokens.
 func TestAPICallWithBlankTokens(t *testing.T) {
-	params := map[string]uint64{}
+	params := Params{}
 	_, err := StravaAPIGetResponse("StravaGetActivityPath", params)
 	if err == nil {
 		t.Error("the strava call should have failed")
 	}
 }
+
+// TestTokenRefresh checks that an expired token is refreshed on Authenticate, that a token rejected by
+// Strava is refreshed only once across concurrent calls, and that the rotated tokens are stored.
+func TestTokenRefresh(t *testing.T) {
+	var mu sync.Mutex
+	refreshes := 0
+	current := "access-0"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/oauth/token" {
+			refreshes++
+			if r.FormValue("refresh_token") != fmt.Sprintf("refresh-%d", refreshes-1) {
+				t.Errorf("unexpected refresh token: %s", r.FormValue("refresh_token"))
+			}
+			current = fmt.Sprintf("access-%d", refreshes)
+			fmt.Fprintf(w, `{"access_token": "%s", "refresh_token": "refresh-%d", "expires_at": %d}`,
+				current, refreshes, time.Now().Add(6*time.Hour).Unix())
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+current {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	secretsFile := filepath.Join(dir, "secrets.json")
+	tokensFile := filepath.Join(dir, "tokens.json")
+	os.WriteFile(secretsFile, []byte(`{"ClientID": 1, "ClientSecret": "secret"}`), 0600)
+	os.WriteFile(tokensFile, []byte(`{"RefreshToken": "refresh-0", "AccessToken": "access-0", "ExpiresAt": 1, "AthleteID": 42}`), 0600)
+
+	client := NewClient(WithBaseURL(server.URL+"/api/v3"), WithOAuthURL(server.URL+"/oauth"),
+		WithSecretsFile(secretsFile), WithTokensFile(tokensFile))
+	err := client.Authenticate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshes != 1 || client.AthleteID() != 42 {
+		t.Fatalf("expected the expired token to be refreshed once for athlete 42, got %d refreshes for %d", refreshes, client.AthleteID())
+	}
+
+	// Strava revokes the token early
+	mu.Lock()
+	current = "revoked"
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetJSON(context.Background(), "athlete", Params{})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if refreshes != 2 {
+		t.Errorf("expected one more refresh after the token was rejected, got %d refreshes", refreshes)
+	}
+
+	var stored Tokens
+	data, _ := os.ReadFile(tokensFile)
+	json.Unmarshal(data, &stored)
+	if stored.RefreshToken != "refresh-2" || stored.AccessToken != "access-2" || stored.AthleteID != 42 {
+		t.Errorf("the rotated tokens were not stored: %+v", stored)
+	}
+}
+
+// TestDeauthorize checks that deauthorizing revokes the access token and deletes the stored tokens,
+// and that tokens Strava already rejects are still deleted.
+func TestDeauthorize(t *testing.T) {
+	revoked := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/deauthorize" || r.Method != "POST" {
+			t.Errorf("unexpected call: %s %s", r.Method, r.URL.Path)
+		}
+		if revoked || r.FormValue("access_token") != "access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		revoked = true
+		w.Write([]byte(`{"access_token": "access"}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	secretsFile := filepath.Join(dir, "secrets.json")
+	tokensFile := filepath.Join(dir, "tokens.json")
+	os.WriteFile(secretsFile, []byte(`{"ClientID": 1, "ClientSecret": "secret"}`), 0600)
+	validTokens := fmt.Sprintf(`{"RefreshToken": "refresh", "AccessToken": "access", "ExpiresAt": %d}`, time.Now().Add(time.Hour).Unix())
+
+	for i := 0; i < 2; i++ {
+		os.WriteFile(tokensFile, []byte(validTokens), 0600)
+		client := NewClient(WithOAuthURL(server.URL+"/oauth"), WithSecretsFile(secretsFile), WithTokensFile(tokensFile))
+		err := client.Deauthorize(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(tokensFile); !os.IsNotExist(err) {
+			t.Errorf("the tokens were not deleted: %v", err)
+		}
+	}
+	if !revoked {
+		t.Error("the access token was not revoked")
+	}
+
+	err := NewClient(WithSecretsFile(secretsFile), WithTokensFile(tokensFile)).Deauthorize(context.Background())
+	if !errors.Is(err, ErrNoTokens) {
+		t.Errorf("expected ErrNoTokens without stored tokens, got %v", err)
+	}
+}
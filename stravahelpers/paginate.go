@@ -0,0 +1,176 @@
+package stravahelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+// DefaultPerPage is the page size used when PageOptions doesn't set one. It is the largest page
+// Strava allows, to use as few calls of the rate limit as possible.
+const DefaultPerPage = 200
+
+// ErrStopPaging can be returned by the function handling a page to stop paging early. Paginate then
+// returns nil rather than the error.
+var ErrStopPaging = errors.New("stop paging")
+
+// PageOptions controls how Paginate pages through a Strava list endpoint.
+type PageOptions struct {
+	PerPage  int  // items per page, defaults to DefaultPerPage
+	MaxPages int  // stop after this many pages, 0 for no limit
+	Prefetch bool // fetch the next page while the current one is being handled
+}
+
+// pageResult is a page fetched by Paginate.
+type pageResult[T any] struct {
+	items []T
+	err   error
+}
+
+// Paginate calls a Strava list endpoint page by page, starting from page 1, and passes each page of
+// results to handle. It stops at the first empty page, when MaxPages is reached, or when handle
+// returns an error. An error from Strava or from handle is returned, except for ErrStopPaging.
+// params are sent with every page, the page and per_page parameters are set by Paginate.
+// It is a function rather than a Client method as methods can't have type parameters, the typed
+// methods such as ActivityPages are usually more convenient.
+func Paginate[T any](ctx context.Context, c *Client, url string, params Params, opts PageOptions, handle func(page []T) error) error {
+	perPage := opts.PerPage
+	if perPage <= 0 {
+		perPage = DefaultPerPage
+	}
+	// abandons a prefetch still in progress when paging stops early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fetch := func(page int) pageResult[T] {
+		pageParams := params.clone().Page(page, perPage)
+
+		var result pageResult[T]
+		result.err = c.getInto(ctx, url, pageParams, &result.items)
+		return result
+	}
+	prefetch := func(page int) <-chan pageResult[T] {
+		next := make(chan pageResult[T], 1) // buffered so an abandoned prefetch doesn't block
+		go func() {
+			next <- fetch(page)
+		}()
+		return next
+	}
+
+	current := fetch(1) // strava pages start at 1
+	for page := 1; ; page++ {
+		if current.err != nil {
+			return fmt.Errorf("page %d: %w", page, current.err)
+		}
+		if len(current.items) == 0 { // empty response, so no more data
+			return nil
+		}
+		logger.DEBUG.Println("Page: ", page)
+		logger.TRACE.Println("Number of responses: ", len(current.items))
+
+		lastPage := opts.MaxPages > 0 && page >= opts.MaxPages
+		var next <-chan pageResult[T]
+		if opts.Prefetch && !lastPage {
+			next = prefetch(page + 1)
+		}
+
+		err := handle(current.items)
+		if errors.Is(err, ErrStopPaging) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if lastPage {
+			return nil
+		}
+
+		if next != nil {
+			current = <-next
+		} else {
+			current = fetch(page + 1)
+		}
+	}
+}
+
+// StravaActivityPages pages through the authenticated athlete's activities using the default Client.
+// params are the before and after filters, see ActivityPages.
+func StravaActivityPages(params Params, opts PageOptions, handle func(page []SummaryActivity) error) error {
+	return StravaActivityPagesContext(context.Background(), params, opts, handle)
+}
+
+// StravaActivityPagesContext is StravaActivityPages with a context that cancels the calls.
+func StravaActivityPagesContext(ctx context.Context, params Params, opts PageOptions, handle func(page []SummaryActivity) error) error {
+	return defaultClient.ActivityPages(ctx, params, opts, handle)
+}
+
+// StravaClubMemberPages pages through the members of a club using the default Client.
+func StravaClubMemberPages(clubID uint64, opts PageOptions, handle func(page []ClubAthlete) error) error {
+	return StravaClubMemberPagesContext(context.Background(), clubID, opts, handle)
+}
+
+// StravaClubMemberPagesContext is StravaClubMemberPages with a context that cancels the calls.
+func StravaClubMemberPagesContext(ctx context.Context, clubID uint64, opts PageOptions, handle func(page []ClubAthlete) error) error {
+	return defaultClient.ClubMemberPages(ctx, clubID, opts, handle)
+}
+
+// StravaClubActivityPages pages through the recent activities of a club's members using the default
+// Client.
+func StravaClubActivityPages(clubID uint64, opts PageOptions, handle func(page []ClubActivity) error) error {
+	return StravaClubActivityPagesContext(context.Background(), clubID, opts, handle)
+}
+
+// StravaClubActivityPagesContext is StravaClubActivityPages with a context that cancels the calls.
+func StravaClubActivityPagesContext(ctx context.Context, clubID uint64, opts PageOptions, handle func(page []ClubActivity) error) error {
+	return defaultClient.ClubActivityPages(ctx, clubID, opts, handle)
+}
+
+// StravaAthleteRoutePages pages through an athlete's routes using the default Client.
+func StravaAthleteRoutePages(athleteID uint64, opts PageOptions, handle func(page []Route) error) error {
+	return StravaAthleteRoutePagesContext(context.Background(), athleteID, opts, handle)
+}
+
+// StravaAthleteRoutePagesContext is StravaAthleteRoutePages with a context that cancels the calls.
+func StravaAthleteRoutePagesContext(ctx context.Context, athleteID uint64, opts PageOptions, handle func(page []Route) error) error {
+	return defaultClient.AthleteRoutePages(ctx, athleteID, opts, handle)
+}
+
+// ActivityPages pages through the authenticated athlete's activities, newest first unless after is
+// given. params are the query parameters to provide to the API, such as Before and After.
+func (c *Client) ActivityPages(ctx context.Context, params Params, opts PageOptions, handle func(page []SummaryActivity) error) error {
+	err := c.requireScope(ScopeActivityRead, "ActivityPages")
+	if err != nil {
+		return err
+	}
+	return Paginate(ctx, c, StravaListActivitiesPath, params, opts, handle)
+}
+
+// ClubMemberPages pages through the members of a club.
+func (c *Client) ClubMemberPages(ctx context.Context, clubID uint64, opts PageOptions, handle func(page []ClubAthlete) error) error {
+	err := c.requireScope(ScopeRead, "ClubMemberPages")
+	if err != nil {
+		return err
+	}
+	return Paginate(ctx, c, fmt.Sprintf(StravaListClubMembersPath, clubID), nil, opts, handle)
+}
+
+// ClubActivityPages pages through the recent activities of a club's members. The authenticated
+// athlete must be a member of the club.
+func (c *Client) ClubActivityPages(ctx context.Context, clubID uint64, opts PageOptions, handle func(page []ClubActivity) error) error {
+	err := c.requireScope(ScopeRead, "ClubActivityPages")
+	if err != nil {
+		return err
+	}
+	return Paginate(ctx, c, fmt.Sprintf(StravaListClubActivitiesPath, clubID), nil, opts, handle)
+}
+
+// AthleteRoutePages pages through an athlete's routes. Private routes are only included with the
+// read_all scope.
+func (c *Client) AthleteRoutePages(ctx context.Context, athleteID uint64, opts PageOptions, handle func(page []Route) error) error {
+	err := c.requireScope(ScopeRead, "AthleteRoutePages")
+	if err != nil {
+		return err
+	}
+	return Paginate(ctx, c, fmt.Sprintf(StravaListAthleteRoutesPath, athleteID), nil, opts, handle)
+}
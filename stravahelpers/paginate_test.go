@@ -0,0 +1,105 @@
+package stravahelpers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// pagedServer serves the ids 1 to total, perPage at a time, and fails with a 404 on the page given in
+// failPage. It returns the server and a function returning the pages that were requested.
+func pagedServer(t *testing.T, total, failPage int) (*httptest.Server, func() []int) {
+	var mu sync.Mutex
+	var requested []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		if r.URL.Query().Get("after") != "100" {
+			t.Errorf("the params were not passed on: %s", r.URL.RawQuery)
+		}
+		mu.Lock()
+		requested = append(requested, page)
+		mu.Unlock()
+		if page == failPage {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var ids []string
+		for id := (page-1)*perPage + 1; id <= page*perPage && id <= total; id++ {
+			ids = append(ids, fmt.Sprintf(`{"id": %d}`, id))
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(ids, ","))
+	}))
+	return server, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), requested...)
+	}
+}
+
+// TestPaginate checks that every page is handled in order, with and without prefetching, up to the
+// first empty page.
+func TestPaginate(t *testing.T) {
+	for _, prefetch := range []bool{false, true} {
+		server, requested := pagedServer(t, 7, 0)
+		client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")))
+
+		var ids []int64
+		err := client.ActivityPages(context.Background(), Params{}.Int("after", 100), PageOptions{PerPage: 3, Prefetch: prefetch},
+			func(page []SummaryActivity) error {
+				for _, activity := range page {
+					ids = append(ids, activity.ID)
+				}
+				return nil
+			})
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(ids) != "[1 2 3 4 5 6 7]" || len(requested()) != 4 {
+			t.Errorf("prefetch %v: unexpected ids %v from pages %v", prefetch, ids, requested())
+		}
+	}
+}
+
+// TestPaginateStops checks that paging stops when the handler asks it to, at MaxPages, and on an
+// error from Strava.
+func TestPaginateStops(t *testing.T) {
+	server, requested := pagedServer(t, 100, 3)
+	defer server.Close()
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")))
+	params := Params{}.Int("after", 100)
+
+	pages := 0
+	err := client.ActivityPages(context.Background(), params, PageOptions{PerPage: 5}, func(page []SummaryActivity) error {
+		pages++
+		return ErrStopPaging
+	})
+	if err != nil || pages != 1 || len(requested()) != 1 {
+		t.Errorf("expected to stop after the first page, got %d pages handled, %d requested: %v", pages, len(requested()), err)
+	}
+
+	pages = 0
+	err = client.ActivityPages(context.Background(), params, PageOptions{PerPage: 5, MaxPages: 2, Prefetch: true}, func(page []SummaryActivity) error {
+		pages++
+		return nil
+	})
+	if err != nil || pages != 2 || len(requested()) != 3 {
+		t.Errorf("expected to stop after MaxPages, got %d pages handled, %d requested: %v", pages, len(requested()), err)
+	}
+
+	pages = 0
+	err = client.ActivityPages(context.Background(), params, PageOptions{PerPage: 5}, func(page []SummaryActivity) error {
+		pages++
+		return nil
+	})
+	if !IsNotFound(err) || pages != 2 {
+		t.Errorf("expected the 404 on page 3 after 2 pages, got %d pages: %v", pages, err)
+	}
+}
@@ -0,0 +1,74 @@
+package stravahelpers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Params are the query parameters of a Strava API call. The methods set a parameter from a typed
+// value and return the Params, so they can be chained:
+//
+//	params := stravahelpers.Params{}.After(start).Before(end)
+//
+// A nil Params can be passed to a call that has no parameters, but must not be set.
+type Params map[string]string
+
+// String sets a string parameter.
+func (p Params) String(key, value string) Params {
+	p[key] = value
+	return p
+}
+
+// Int sets an integer parameter, such as an id.
+func (p Params) Int(key string, value int64) Params {
+	p[key] = strconv.FormatInt(value, 10)
+	return p
+}
+
+// Uint sets an unsigned integer parameter.
+func (p Params) Uint(key string, value uint64) Params {
+	p[key] = strconv.FormatUint(value, 10)
+	return p
+}
+
+// Bool sets a boolean parameter, such as key_by_type or include_all_efforts.
+func (p Params) Bool(key string, value bool) Params {
+	p[key] = strconv.FormatBool(value)
+	return p
+}
+
+// Time sets a time parameter as seconds since epoch, which is how Strava takes times.
+func (p Params) Time(key string, value time.Time) Params {
+	return p.Int(key, value.Unix())
+}
+
+// List sets a parameter to the values joined by commas, such as the keys of a streams call.
+func (p Params) List(key string, values ...string) Params {
+	p[key] = strings.Join(values, ",")
+	return p
+}
+
+// Before sets the before parameter, to only list activities that started before t.
+func (p Params) Before(t time.Time) Params {
+	return p.Time("before", t)
+}
+
+// After sets the after parameter, to only list activities that started after t.
+func (p Params) After(t time.Time) Params {
+	return p.Time("after", t)
+}
+
+// Page sets the page and per_page parameters. Pages start at 1.
+func (p Params) Page(page, perPage int) Params {
+	return p.Int("page", int64(page)).Int("per_page", int64(perPage))
+}
+
+// clone returns a copy of the Params that can be changed without changing the original.
+func (p Params) clone() Params {
+	copied := make(Params, len(p))
+	for key, value := range p {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,57 @@
+package stravahelpers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestParams checks that each kind of typed value is formatted the way Strava expects.
+func TestParams(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	params := Params{}.After(start).Before(start.AddDate(1, 0, 0)).Page(2, 50).
+		Bool("include_all_efforts", true).List("keys", StreamTime, StreamLatLng).String("name", "Morning Ride")
+
+	expected := Params{
+		"after":               "1577836800",
+		"before":              "1609459200",
+		"page":                "2",
+		"per_page":            "50",
+		"include_all_efforts": "true",
+		"keys":                "time,latlng",
+		"name":                "Morning Ride",
+	}
+	for key, value := range expected {
+		if params[key] != value {
+			t.Errorf("expected %s=%s, got %s", key, value, params[key])
+		}
+	}
+}
+
+// TestGetActivityStreams checks that the streams are requested by type and unmarshal into the
+// typed StreamSet.
+func TestGetActivityStreams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/activities/1234/streams" || r.URL.Query().Get("keys") != "time,latlng" || r.URL.Query().Get("key_by_type") != "true" {
+			t.Errorf("unexpected call: %s", r.URL)
+		}
+		w.Write([]byte(`{"latlng": {"data": [[49.1, -123.1], [49.2, -123.2]], "series_type": "distance", "original_size": 2, "resolution": "high"},
+			"time": {"data": [0, 5], "series_type": "distance", "original_size": 2, "resolution": "high"},
+			"distance": {"data": [0.0, 12.5], "series_type": "distance", "original_size": 2, "resolution": "high"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")))
+	streams, err := client.GetActivityStreams(context.Background(), 1234, StreamTime, StreamLatLng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streams.Time == nil || streams.Time.Data[1] != 5 || streams.LatLng.Data[1][0] != 49.2 || streams.Distance.Data[1] != 12.5 {
+		t.Errorf("unexpected streams: %+v", streams)
+	}
+	if streams.Altitude != nil {
+		t.Errorf("a stream that wasn't returned should be nil: %+v", streams.Altitude)
+	}
+}
@@ -0,0 +1,146 @@
+package stravahelpers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// A profile keeps the tokens, and anything else stored per athlete, for one of several athletes
+// authorized with the same API application. The default profile is kept in the current directory
+// where ./tokens.json always lived, named profiles are kept in ./profiles/<name>/.
+
+// DefaultProfile is the name of the profile kept in the current directory.
+const DefaultProfile = "default"
+
+// ProfilesDir is the directory the named profiles are kept in.
+const ProfilesDir = "./profiles"
+
+// TokensFileName and EncryptedTokensFileName are the names of the token files within a profile.
+// EnvTokensFileName is the suggested name of the file EnvTokenStore writes rotated tokens to, which
+// can't be either of them.
+const (
+	TokensFileName          = "tokens.json"
+	EncryptedTokensFileName = "tokens.enc"
+	EnvTokensFileName       = "tokens.env"
+)
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// CheckProfileName returns an error if the name can't be used for a profile, since it is used as a
+// directory name.
+func CheckProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("profile name %q must only contain letters, numbers, - and _", name)
+	}
+	return nil
+}
+
+// ProfileDir returns the directory a profile is kept in.
+func ProfileDir(name string) string {
+	if name == DefaultProfile {
+		return "."
+	}
+	return filepath.Join(ProfilesDir, name)
+}
+
+// ProfileTokenStore returns the TokenStore of a profile, creating the profile's directory if needed.
+// kind and passphrase are as for NewTokenStore.
+func ProfileTokenStore(name, kind, passphrase string) (TokenStore, error) {
+	err := CheckProfileName(name)
+	if err != nil {
+		return nil, err
+	}
+	dir := ProfileDir(name)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	fileName := TokensFileName
+	switch kind {
+	case "encrypted":
+		fileName = EncryptedTokensFileName
+	case "env":
+		// the tokens are in the environment, they are only written to a file that is asked for
+		return NewTokenStore(kind, "", passphrase)
+	}
+	return NewTokenStore(kind, filepath.Join(dir, fileName), passphrase)
+}
+
+// ProfileActivityStore opens the ActivityStore of a profile, creating the profile's directory if
+// needed.
+func ProfileActivityStore(name string) (*ActivityStore, error) {
+	err := CheckProfileName(name)
+	if err != nil {
+		return nil, err
+	}
+	dir := ProfileDir(name)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return OpenActivityStore(filepath.Join(dir, ActivitiesFileName))
+}
+
+// ListProfiles returns the names of the profiles that have tokens or activities stored, sorted by
+// name.
+func ListProfiles() ([]string, error) {
+	var names []string
+	if hasProfileFile(ProfileDir(DefaultProfile)) {
+		names = append(names, DefaultProfile)
+	}
+
+	entries, err := ioutil.ReadDir(ProfilesDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return names, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && CheckProfileName(entry.Name()) == nil && hasProfileFile(ProfileDir(entry.Name())) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RemoveProfile deletes the profile's tokens and stored activities. A named profile's directory is
+// removed along with anything else stored in it.
+func RemoveProfile(name string) error {
+	err := CheckProfileName(name)
+	if err != nil {
+		return err
+	}
+	dir := ProfileDir(name)
+	if !hasProfileFile(dir) {
+		return fmt.Errorf("there is no profile named %s", name)
+	}
+
+	if name == DefaultProfile {
+		for _, fileName := range []string{TokensFileName, EncryptedTokensFileName, ActivitiesFileName} {
+			err = removeIfExists(filepath.Join(dir, fileName))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
+
+// hasProfileFile returns true if the directory has a plain or encrypted token file, or stored
+// activities, in it.
+func hasProfileFile(dir string) bool {
+	for _, fileName := range []string{TokensFileName, EncryptedTokensFileName, ActivitiesFileName} {
+		if info, err := os.Stat(filepath.Join(dir, fileName)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package stravahelpers
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestProfiles ensures that profiles are listed once they have tokens, and that removing one deletes
+// its tokens.
+func TestProfiles(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, name := range []string{DefaultProfile, "bob", "alice"} {
+		store, err := ProfileTokenStore(name, "file", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Save(Tokens{RefreshToken: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = ProfileTokenStore("no-tokens", "file", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := ListProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(profiles, []string{"alice", "bob", DefaultProfile}) {
+		t.Errorf("unexpected profiles: %v", profiles)
+	}
+
+	for _, name := range []string{"bob", DefaultProfile} {
+		err = RemoveProfile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	profiles, _ = ListProfiles()
+	if !reflect.DeepEqual(profiles, []string{"alice"}) {
+		t.Errorf("unexpected profiles after removing: %v", profiles)
+	}
+	if RemoveProfile("bob") == nil {
+		t.Error("expected an error removing a profile that doesn't exist")
+	}
+	if _, err = ProfileTokenStore("../escape", "file", ""); err == nil {
+		t.Error("expected an error for a profile name that isn't a plain directory name")
+	}
+}
+
+// TestActivitiesOnlyProfile checks that a profile with stored activities but no tokens, such as one
+// made by importing an export, is listed and can be removed.
+func TestActivitiesOnlyProfile(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	store, err := ProfileActivityStore("imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var activity SummaryActivity
+	activity.ID = 1
+	store.Put(activity)
+	err = store.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := ListProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(profiles, []string{"imported"}) {
+		t.Errorf("expected the imported profile to be listed, got %v", profiles)
+	}
+	err = RemoveProfile("imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ProfileDir("imported")); !os.IsNotExist(err) {
+		t.Errorf("expected the profile to be removed, got %v", err)
+	}
+}
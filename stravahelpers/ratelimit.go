@@ -0,0 +1,168 @@
+package stravahelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+// ErrRateLimited is returned when a Strava quota is exhausted and the Client is set to fail
+// fast with RateLimitFail rather than wait for the quota to reset.
+var ErrRateLimited = errors.New("Strava rate limit exceeded")
+
+// shortTermWindow is how often Strava resets the short term quota. Resets happen on the natural
+// quarter hours (0, 15, 30 and 45 minutes past the hour), the daily quota resets at midnight UTC.
+const shortTermWindow = 15 * time.Minute
+
+// defaultRateLimitThreshold is the fraction of a quota that can be used before the Client pauses.
+const defaultRateLimitThreshold = 0.95
+
+// RateLimit is the state of the Strava API quotas as last reported by Strava in the
+// X-RateLimit-Limit and X-RateLimit-Usage headers.
+type RateLimit struct {
+	ShortTermLimit int
+	ShortTermUsage int
+	DailyLimit     int
+	DailyUsage     int
+	Updated        time.Time // when Strava last reported the usage, zero if it never has
+}
+
+// RateLimitPolicy decides what a Client does when a Strava quota is exhausted.
+type RateLimitPolicy int
+
+const (
+	// RateLimitWait pauses calls until the quota resets, then resumes them. This is the default.
+	RateLimitWait RateLimitPolicy = iota
+	// RateLimitFail fails calls with ErrRateLimited instead of waiting.
+	RateLimitFail
+)
+
+// WithRateLimitPolicy sets what the Client does when a Strava quota is exhausted.
+func WithRateLimitPolicy(policy RateLimitPolicy) Option {
+	return func(c *Client) {
+		c.ratePolicy = policy
+	}
+}
+
+// WithRateLimitThreshold sets the fraction, between 0 and 1, of a quota that can be used before the
+// Client pauses or fails. It defaults to 0.95 to leave some room for other users of the same API
+// application.
+func WithRateLimitThreshold(threshold float64) Option {
+	return func(c *Client) {
+		c.rateThreshold = threshold
+	}
+}
+
+// RateLimit returns the Strava quota usage as of the last call the Client made.
+func (c *Client) RateLimit() RateLimit {
+	c.rateMu.Lock()
+	defer c.rateMu.Unlock()
+	return c.rateLimit
+}
+
+// waitForRateLimit blocks until the quota allows another call to be made, or returns ErrRateLimited
+// if the Client is set to fail fast.
+func (c *Client) waitForRateLimit(ctx context.Context) error {
+	c.rateMu.Lock()
+	resetAt := c.rateLimitResetLocked(c.now())
+	c.rateMu.Unlock()
+
+	if resetAt.IsZero() {
+		return nil
+	}
+	wait := resetAt.Sub(c.now())
+	if c.ratePolicy == RateLimitFail {
+		return fmt.Errorf("%w: quota resets in %s", ErrRateLimited, wait.Round(time.Second))
+	}
+	logger.WARN.Printf("Strava rate limit reached, pausing for %s until %s\n", wait.Round(time.Second), resetAt)
+	return c.sleep(ctx, wait)
+}
+
+// rateLimitResetLocked returns when the exhausted quota resets, or the zero time if there is quota
+// left. rateMu must be held.
+func (c *Client) rateLimitResetLocked(now time.Time) time.Time {
+	if now.Before(c.blockedUntil) {
+		return c.blockedUntil
+	}
+	if c.rateLimit.Updated.IsZero() {
+		return time.Time{}
+	}
+
+	dailyReset := nextDailyReset(c.rateLimit.Updated)
+	if now.Before(dailyReset) && quotaExhausted(c.rateLimit.DailyUsage, c.rateLimit.DailyLimit, c.rateThreshold) {
+		return dailyReset
+	}
+	shortReset := nextShortTermReset(c.rateLimit.Updated)
+	if now.Before(shortReset) && quotaExhausted(c.rateLimit.ShortTermUsage, c.rateLimit.ShortTermLimit, c.rateThreshold) {
+		return shortReset
+	}
+	return time.Time{}
+}
+
+// updateRateLimit records the quota usage reported in a response. A 429 - Too Many Requests blocks
+// further calls until the quota resets, even if Strava did not report the usage.
+func (c *Client) updateRateLimit(resp *http.Response) {
+	c.rateMu.Lock()
+	defer c.rateMu.Unlock()
+
+	now := c.now()
+	shortLimit, dailyLimit, okLimit := parseRateLimitHeader(resp.Header.Get("X-RateLimit-Limit"))
+	shortUsage, dailyUsage, okUsage := parseRateLimitHeader(resp.Header.Get("X-RateLimit-Usage"))
+	if okLimit && okUsage {
+		c.rateLimit = RateLimit{
+			ShortTermLimit: shortLimit,
+			ShortTermUsage: shortUsage,
+			DailyLimit:     dailyLimit,
+			DailyUsage:     dailyUsage,
+			Updated:        now,
+		}
+		logger.DEBUG.Printf("Strava rate limit usage: %d/%d short term, %d/%d daily\n", shortUsage, shortLimit, dailyUsage, dailyLimit)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		c.blockedUntil = nextShortTermReset(now)
+		if okLimit && okUsage && quotaExhausted(dailyUsage, dailyLimit, 1) {
+			c.blockedUntil = nextDailyReset(now)
+		}
+		logger.WARN.Println("Strava responded 429 - Too Many Requests, blocked until ", c.blockedUntil)
+	}
+}
+
+// parseRateLimitHeader parses Strava's "short term,daily" rate limit header values.
+func parseRateLimitHeader(value string) (int, int, bool) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	shortTerm, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	daily, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, false
+	}
+	return shortTerm, daily, true
+}
+
+// quotaExhausted returns true if usage has reached the threshold fraction of the limit.
+func quotaExhausted(usage, limit int, threshold float64) bool {
+	return limit > 0 && float64(usage) >= float64(limit)*threshold
+}
+
+// nextShortTermReset returns the next quarter hour after t.
+func nextShortTermReset(t time.Time) time.Time {
+	return t.UTC().Truncate(shortTermWindow).Add(shortTermWindow)
+}
+
+// nextDailyReset returns the next midnight UTC after t.
+func nextDailyReset(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,66 @@
+package stravahelpers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// rateLimitedServer returns a server that reports the given usage, and responds 429 to the first
+// tooManyRequests calls.
+func rateLimitedServer(tooManyRequests int, usage string) *httptest.Server {
+	calls := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-RateLimit-Limit", "100,1000")
+		w.Header().Set("X-RateLimit-Usage", usage)
+		if calls <= tooManyRequests {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+}
+
+// TestRateLimitWait checks a 429 pauses until the next quarter hour and then the call is retried.
+func TestRateLimitWait(t *testing.T) {
+	server := rateLimitedServer(1, "100,200")
+	defer server.Close()
+
+	now := time.Date(2022, 4, 23, 10, 7, 30, 0, time.UTC)
+	var slept time.Duration
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")))
+	client.now = func() time.Time { return now.Add(slept) }
+	client.sleep = func(ctx context.Context, d time.Duration) error { slept += d; return nil }
+
+	_, err := client.GetJSON(context.Background(), "athlete", Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slept != 7*time.Minute+30*time.Second {
+		t.Errorf("expected to wait until the quarter hour, waited %s", slept)
+	}
+	limit := client.RateLimit()
+	if limit.ShortTermLimit != 100 || limit.ShortTermUsage != 100 || limit.DailyLimit != 1000 || limit.DailyUsage != 200 {
+		t.Errorf("unexpected rate limit: %+v", limit)
+	}
+}
+
+// TestRateLimitFail checks a client set to fail fast returns ErrRateLimited once the quota is used up.
+func TestRateLimitFail(t *testing.T) {
+	server := rateLimitedServer(0, "99,200")
+	defer server.Close()
+
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")), WithRateLimitPolicy(RateLimitFail))
+	_, err := client.GetJSON(context.Background(), "athlete", Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetJSON(context.Background(), "athlete", Params{})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+}
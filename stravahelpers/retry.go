@@ -0,0 +1,141 @@
+package stravahelpers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+// RetryPolicy decides how a Client retries calls that fail for transient reasons: connection errors,
+// timeouts and 5xx responses from Strava. Only GET calls are retried, since they are safe to repeat.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first, 1 or less disables retries
+	BaseDelay   time.Duration // delay before the first retry, doubled for every retry after it
+	MaxDelay    time.Duration // longest delay between attempts, unless Strava asks for longer with Retry-After
+}
+
+// DefaultRetryPolicy is the RetryPolicy of a Client created without WithRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// WithRetryPolicy sets how the Client retries calls that fail for transient reasons.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retryPolicy = policy
+	}
+}
+
+// backoff returns how long to wait before the given retry, starting at 1. The delay grows
+// exponentially and is jittered so that concurrent callers don't retry in lock step.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// full range between half and all of the delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter returns the delay requested by a Retry-After header, which is either a number of
+// seconds or an HTTP date. It returns false if there is no usable header.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if date.Before(now) {
+			return 0, true
+		}
+		return date.Sub(now), true
+	}
+	return 0, false
+}
+
+// isRetryableStatus returns true for the statuses that indicate a transient Strava failure.
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do makes the request, waiting on the rate limit and retrying transient failures according to
+// the Client's RetryPolicy. It returns the body of a 200 response.
+func (c *Client) do(request *http.Request) ([]byte, error) {
+	retryable := request.Method == "GET" || request.Method == "HEAD"
+	endpoint := request.Method + " " + request.URL.Path
+
+	for attempt := 1; ; attempt++ {
+		err := c.waitForRateLimit(request.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		var delay time.Duration
+		resp, err := c.httpClient.Do(request)
+		if err != nil && request.Context().Err() != nil {
+			return nil, request.Context().Err()
+		} else if err != nil {
+			err = fmt.Errorf("Unable to access %s: %s", endpoint, err)
+		} else {
+			c.updateRateLimit(resp)
+			var body []byte
+			body, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			switch {
+			case resp.StatusCode == http.StatusTooManyRequests:
+				// wait out the quota and try again, waitForRateLimit decides whether to wait or fail
+				attempt--
+				continue
+			case resp.StatusCode == 200 && err == nil:
+				if attempt > 1 {
+					logger.INFO.Printf("%s succeeded after %d retries\n", endpoint, attempt-1)
+				}
+				return body, nil
+			case resp.StatusCode == 200:
+				err = fmt.Errorf("Error reading body: %s", err)
+			case isRetryableStatus(resp.StatusCode):
+				err = newAPIError(request, resp, body)
+				delay, _ = retryAfter(resp, c.now())
+			default:
+				// anything else other than 200 is an error that retrying will not fix
+				return nil, newAPIError(request, resp, body)
+			}
+		}
+
+		if !retryable || attempt >= c.retryPolicy.MaxAttempts {
+			if attempt > 1 {
+				return nil, fmt.Errorf("%s failed after %d attempts: %w", endpoint, attempt, err)
+			}
+			return nil, err
+		}
+		if delay == 0 {
+			delay = c.retryPolicy.backoff(attempt)
+		}
+		logger.WARN.Printf("%s failed, retry %d of %d in %s: %s\n", endpoint, attempt, c.retryPolicy.MaxAttempts-1, delay, err)
+		err = c.sleep(request.Context(), delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package stravahelpers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRetryTransientFailures checks that 5xx responses are retried, honoring Retry-After, and that
+// other failures are not.
+func TestRetryTransientFailures(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case calls == 1:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case calls == 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}))
+	client.sleep = func(ctx context.Context, d time.Duration) error { delays = append(delays, d); return nil }
+
+	_, err := client.GetJSON(context.Background(), "athlete", Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || len(delays) != 2 {
+		t.Fatalf("expected 3 calls and 2 retries, got %d calls and %v", calls, delays)
+	}
+	if delays[0] != 7*time.Second {
+		t.Errorf("expected Retry-After to be honored, waited %s", delays[0])
+	}
+	if delays[1] < time.Second || delays[1] > 2*time.Second {
+		t.Errorf("expected a jittered backoff of 1s to 2s, waited %s", delays[1])
+	}
+
+	calls = 0
+	_, err = client.GetJSON(context.Background(), "missing", Params{})
+	if err == nil || calls != 1 {
+		t.Errorf("a 404 should fail without retrying, got %d calls and %v", calls, err)
+	}
+}
+
+// TestCancelStopsRetries checks that a cancelled context stops a call instead of it being retried.
+func TestCancelStopsRetries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := NewClient(WithBaseURL(server.URL), WithTokenSource(StaticToken("token")),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour}))
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := client.GetJSON(ctx, "athlete", Params{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
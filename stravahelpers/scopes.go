@@ -0,0 +1,161 @@
+package stravahelpers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+// Scope is a permission the athlete grants the application when authorizing it.
+type Scope string
+
+// The scopes Strava supports. See https://developers.strava.com/docs/authentication/
+const (
+	// ScopeRead reads public segments, routes, profile data, posts, events, club feeds and leaderboards
+	ScopeRead Scope = "read"
+	// ScopeReadAll reads private routes, private segments and private events
+	ScopeReadAll Scope = "read_all"
+	// ScopeProfileReadAll reads all profile information even if the athlete has limited its visibility
+	ScopeProfileReadAll Scope = "profile:read_all"
+	// ScopeProfileWrite updates the athlete's weight and FTP, and stars or unstars segments
+	ScopeProfileWrite Scope = "profile:write"
+	// ScopeActivityRead reads the athlete's activities that are visible to Everyone and Followers
+	ScopeActivityRead Scope = "activity:read"
+	// ScopeActivityReadAll is the same as ScopeActivityRead, plus activities that are Only Me
+	ScopeActivityReadAll Scope = "activity:read_all"
+	// ScopeActivityWrite creates manual activities and uploads, and edits the athlete's activities
+	ScopeActivityWrite Scope = "activity:write"
+)
+
+// DefaultScopes are requested when neither WithScopes nor the secrets file choose the scopes.
+var DefaultScopes = []Scope{ScopeActivityReadAll}
+
+// ErrScopeNotGranted is returned, without calling Strava, when a call needs a scope the athlete
+// declined when authorizing the application.
+var ErrScopeNotGranted = errors.New("scope not granted")
+
+// impliedScopes lists the scopes that are included in a broader scope.
+var impliedScopes = map[Scope]Scope{
+	ScopeReadAll:         ScopeRead,
+	ScopeActivityReadAll: ScopeActivityRead,
+}
+
+// WithScopes sets the scopes requested when the athlete authorizes the application. They can also be
+// set for the application with a Scopes list in the secrets file.
+func WithScopes(scopes ...Scope) Option {
+	return func(c *Client) {
+		c.scopes = scopes
+	}
+}
+
+// knownScope returns true for the scopes Strava supports.
+func knownScope(scope Scope) bool {
+	switch scope {
+	case ScopeRead, ScopeReadAll, ScopeProfileReadAll, ScopeProfileWrite, ScopeActivityRead, ScopeActivityReadAll, ScopeActivityWrite:
+		return true
+	}
+	return false
+}
+
+// splitScopes splits a comma separated list of scopes, leaving out empty names.
+func splitScopes(list string) []Scope {
+	var scopes []Scope
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			scopes = append(scopes, Scope(name))
+		}
+	}
+	return scopes
+}
+
+// ParseScopes parses a comma separated list of scopes to request, such as from the command line, and
+// returns an error for any scope Strava doesn't support.
+func ParseScopes(list string) ([]Scope, error) {
+	scopes := splitScopes(list)
+	for _, scope := range scopes {
+		if !knownScope(scope) {
+			return nil, fmt.Errorf("unknown Strava scope: %s", scope)
+		}
+	}
+	return scopes, nil
+}
+
+// parseGrantedScopes parses a comma separated list of the scopes the athlete granted, such as Strava
+// returns in the scope parameter of the authorization redirect. Scopes that aren't known are kept,
+// so that a scope Strava adds later doesn't stop the tokens from loading.
+func parseGrantedScopes(list string) []Scope {
+	scopes := splitScopes(list)
+	for _, scope := range scopes {
+		if !knownScope(scope) {
+			logger.WARN.Println("Strava granted an unknown scope: ", scope)
+		}
+	}
+	return scopes
+}
+
+// joinScopes returns the scopes as a comma separated list.
+func joinScopes(scopes []Scope) string {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+	return strings.Join(names, ",")
+}
+
+// hasScope returns true if the required scope is in the list, or is implied by one that is.
+func hasScope(scopes []Scope, required Scope) bool {
+	for _, scope := range scopes {
+		if scope == required || impliedScopes[scope] == required {
+			return true
+		}
+	}
+	return false
+}
+
+// missingScopes returns the requested scopes that are not in the granted scopes.
+func missingScopes(requested, granted []Scope) []Scope {
+	var missing []Scope
+	for _, scope := range requested {
+		if !hasScope(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// requestedScopes returns the scopes to ask the athlete for, from WithScopes, then the secrets file,
+// then DefaultScopes.
+func (c *Client) requestedScopes(sec secrets) []Scope {
+	if len(c.scopes) > 0 {
+		return c.scopes
+	}
+	if len(sec.Scopes) > 0 {
+		return sec.Scopes
+	}
+	return DefaultScopes
+}
+
+// GrantedScopes returns the scopes the athlete granted the application, or nil if they are not known,
+// such as for tokens stored before the scopes were recorded.
+func (c *Client) GrantedScopes() []Scope {
+	source, ok := c.tokens.(*oauthTokenSource)
+	if !ok {
+		return nil
+	}
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	return source.auth.Scopes
+}
+
+// requireScope returns an error if the athlete is known to have declined the scope needed by a call.
+func (c *Client) requireScope(required Scope, call string) error {
+	granted := c.GrantedScopes()
+	if granted == nil || hasScope(granted, required) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s needs the %s scope, but the athlete only granted %s. Authorize the application again and allow it",
+		ErrScopeNotGranted, call, required, joinScopes(granted))
+}
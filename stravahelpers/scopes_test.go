@@ -0,0 +1,95 @@
+package stravahelpers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestParseScopes checks a list of scopes is parsed, and unknown scopes are rejected when they are
+// requested but kept when Strava grants them.
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("read,activity:read_all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scopes) != 2 || !hasScope(scopes, ScopeActivityRead) || hasScope(scopes, ScopeActivityWrite) {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+	_, err = ParseScopes("read,activity:everything")
+	if err == nil {
+		t.Error("expected an unknown scope to fail")
+	}
+	granted := parseGrantedScopes("read, activity:read_all,activity:everything,")
+	if len(granted) != 3 || !hasScope(granted, ScopeActivityRead) || granted[2] != "activity:everything" {
+		t.Errorf("expected the unknown granted scope to be kept, got %v", granted)
+	}
+}
+
+// TestDeclinedScope checks that a call needing a declined scope fails without calling Strava.
+func TestDeclinedScope(t *testing.T) {
+	client := NewClient(WithBaseURL("http://127.0.0.1:1/"))
+	client.tokens = &oauthTokenSource{client: client, auth: Tokens{
+		AccessToken: "token",
+		ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+		Scopes:      []Scope{ScopeRead},
+	}}
+
+	_, err := client.ListActivities(context.Background(), Params{})
+	if !errors.Is(err, ErrScopeNotGranted) {
+		t.Errorf("expected ErrScopeNotGranted, got %v", err)
+	}
+
+	// every helper checks its scope before calling Strava
+	client.tokens.(*oauthTokenSource).auth.Scopes = []Scope{ScopeActivityWrite}
+	ctx := context.Background()
+	calls := map[string]func() error{
+		"GetAthlete": func() error {
+			_, err := client.GetAthlete(ctx)
+			return err
+		},
+		"GetAthleteStats": func() error {
+			_, err := client.GetAthleteStats(ctx, 1)
+			return err
+		},
+		"GetActivity": func() error {
+			_, err := client.GetActivity(ctx, 1)
+			return err
+		},
+		"GetActivityStreams": func() error {
+			_, err := client.GetActivityStreams(ctx, 1)
+			return err
+		},
+		"ActivityPages": func() error {
+			return client.ActivityPages(ctx, nil, PageOptions{}, func([]SummaryActivity) error { return nil })
+		},
+		"ClubMemberPages": func() error {
+			return client.ClubMemberPages(ctx, 1, PageOptions{}, func([]ClubAthlete) error { return nil })
+		},
+		"ClubActivityPages": func() error {
+			return client.ClubActivityPages(ctx, 1, PageOptions{}, func([]ClubActivity) error { return nil })
+		},
+		"AthleteRoutePages": func() error {
+			return client.AthleteRoutePages(ctx, 1, PageOptions{}, func([]Route) error { return nil })
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrScopeNotGranted) {
+			t.Errorf("%s: expected ErrScopeNotGranted, got %v", name, err)
+		}
+	}
+}
+
+// TestUnknownGrantedScope checks that stored tokens with a scope Strava added later still load.
+func TestUnknownGrantedScope(t *testing.T) {
+	t.Setenv(EnvRefreshToken, "refresh")
+	t.Setenv(EnvScopes, "read,activity:read_all,activity:everything")
+	auth, err := EnvTokenStore{}.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auth.Scopes) != 3 || !hasScope(auth.Scopes, ScopeActivityReadAll) {
+		t.Errorf("unexpected scopes: %v", auth.Scopes)
+	}
+}
@@ -0,0 +1,462 @@
+package stravatest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/stravahelpers"
+)
+
+// The page size the list APIs use when per_page isn't given, and the largest Strava allows.
+const (
+	defaultPerPage = 30
+	maxPerPage     = 200
+)
+
+// recentWindow is how far back the recent totals in the athlete's stats go.
+const recentWindow = 28 * 24 * time.Hour
+
+// tokenResponse is the body of a successful call to the OAuth token endpoint.
+type tokenResponse struct {
+	TokenType    string                        `json:"token_type"`
+	AccessToken  string                        `json:"access_token"`
+	RefreshToken string                        `json:"refresh_token"`
+	ExpiresAt    int64                         `json:"expires_at"`
+	ExpiresIn    int64                         `json:"expires_in"`
+	Athlete      *stravahelpers.SummaryAthlete `json:"athlete,omitempty"`
+}
+
+// activityTotal is the totals of one sport in the athlete's stats.
+type activityTotal struct {
+	Count            int     `json:"count"`
+	Distance         float64 `json:"distance"`
+	MovingTime       int     `json:"moving_time"`
+	ElapsedTime      int     `json:"elapsed_time"`
+	ElevationGain    float64 `json:"elevation_gain"`
+	AchievementCount int     `json:"achievement_count"`
+}
+
+// add adds the activity to the totals.
+func (t *activityTotal) add(activity stravahelpers.SummaryActivity) {
+	t.Count++
+	t.Distance += activity.Distance
+	t.MovingTime += activity.MovingTime
+	t.ElapsedTime += activity.ElapsedTime
+	t.ElevationGain += activity.TotalElevationGain
+	t.AchievementCount += activity.AchievementCount
+}
+
+// activityStats is the athlete's stats as returned by StravaGetAtheleteStatsPath.
+type activityStats struct {
+	BiggestRideDistance       float64       `json:"biggest_ride_distance"`
+	BiggestClimbElevationGain float64       `json:"biggest_climb_elevation_gain"`
+	RecentRideTotals          activityTotal `json:"recent_ride_totals"`
+	RecentRunTotals           activityTotal `json:"recent_run_totals"`
+	RecentSwimTotals          activityTotal `json:"recent_swim_totals"`
+	YTDRideTotals             activityTotal `json:"ytd_ride_totals"`
+	YTDRunTotals              activityTotal `json:"ytd_run_totals"`
+	YTDSwimTotals             activityTotal `json:"ytd_swim_totals"`
+	AllRideTotals             activityTotal `json:"all_ride_totals"`
+	AllRunTotals              activityTotal `json:"all_run_totals"`
+	AllSwimTotals             activityTotal `json:"all_swim_totals"`
+}
+
+// handleAuthorize emulates the page where the athlete authorizes the application, as if they
+// agreed to every requested scope. It redirects straight back to the redirect_uri with AuthCode.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != strconv.Itoa(ClientID) {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "client_id", "invalid")
+		return
+	}
+	if query.Get("response_type") != "code" {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "response_type", "invalid")
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("redirect_uri") == "" {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "redirect_uri", "invalid")
+		return
+	}
+
+	// Strava always grants read, along with the scopes that were asked for
+	scopes := []string{string(stravahelpers.ScopeRead)}
+	for _, scope := range strings.Split(query.Get("scope"), ",") {
+		if scope != "" && scope != string(stravahelpers.ScopeRead) {
+			scopes = append(scopes, scope)
+		}
+	}
+	s.mu.Lock()
+	s.usedCode = false
+	s.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("state", query.Get("state"))
+	params.Set("code", AuthCode)
+	params.Set("scope", strings.Join(scopes, ","))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// handleToken emulates the OAuth token endpoint, exchanging AuthCode or a refresh token for new
+// tokens. The refresh token is rotated every time, so callers must store the new one.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeFault(w, http.StatusMethodNotAllowed, "Method Not Allowed", "Application", "method", "invalid")
+		return
+	}
+	if r.PostFormValue("client_id") != strconv.Itoa(ClientID) {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "client_id", "invalid")
+		return
+	}
+	if r.PostFormValue("client_secret") != ClientSecret {
+		writeFault(w, http.StatusUnauthorized, "Bad Request", "Application", "client_secret", "invalid")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var athlete *stravahelpers.SummaryAthlete
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		// codes can only be used once
+		if r.PostFormValue("code") != AuthCode || s.usedCode {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "AuthorizationCode", "code", "invalid")
+			return
+		}
+		s.usedCode = true
+		athlete = &s.fixtures.Athlete
+	case "refresh_token":
+		refreshToken := r.PostFormValue("refresh_token")
+		if !s.refreshTokens[refreshToken] {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "RefreshToken", "refresh_token", "invalid")
+			return
+		}
+		delete(s.refreshTokens, refreshToken)
+	default:
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "grant_type", "invalid")
+		return
+	}
+
+	tokens := s.issueLocked()
+	writeJSON(w, tokenResponse{
+		TokenType:    "Bearer",
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+		ExpiresIn:    int64(TokenLifetime.Seconds()),
+		Athlete:      athlete,
+	})
+}
+
+// handleDeauthorize emulates the OAuth deauthorize endpoint, which revokes every token issued to the
+// application for the athlete.
+func (s *Server) handleDeauthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeFault(w, http.StatusMethodNotAllowed, "Method Not Allowed", "Application", "method", "invalid")
+		return
+	}
+	accessToken := r.PostFormValue("access_token")
+	if accessToken == "" {
+		accessToken = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.validLocked(accessToken) {
+		writeFault(w, http.StatusUnauthorized, "Authorization Error", "Athlete", "access_token", "invalid")
+		return
+	}
+	s.accessTokens = map[string]time.Time{}
+	s.refreshTokens = map[string]bool{}
+	writeJSON(w, map[string]string{"access_token": accessToken})
+}
+
+// validLocked returns true if the access token was issued and hasn't expired. mu must be held.
+func (s *Server) validLocked(accessToken string) bool {
+	expiresAt, ok := s.accessTokens[accessToken]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// handleAPI checks the access token and routes the API calls by their path.
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.validLocked(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		writeFault(w, http.StatusUnauthorized, "Authorization Error", "Athlete", "access_token", "invalid")
+		return
+	}
+	if r.Method != "GET" {
+		writeFault(w, http.StatusMethodNotAllowed, "Method Not Allowed", "Application", "method", "invalid")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPath), "/"), "/")
+	var id int64
+	if len(parts) >= 2 {
+		id, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+	switch {
+	case len(parts) == 1 && parts[0] == "athlete":
+		writeJSON(w, s.fixtures.Athlete)
+	case len(parts) == 2 && parts[0] == "athlete" && parts[1] == "activities":
+		s.listActivities(w, r.URL.Query())
+	case len(parts) == 2 && parts[0] == "activities":
+		s.getActivity(w, id)
+	case len(parts) == 3 && parts[0] == "activities" && parts[2] == "streams":
+		s.getStreams(w, id, r.URL.Query())
+	case len(parts) == 3 && parts[0] == "clubs" && (parts[2] == "members" || parts[2] == "activities"):
+		s.listClub(w, id, parts[2], r.URL.Query())
+	case len(parts) == 3 && parts[0] == "athletes" && parts[2] == "stats":
+		s.getStats(w, id)
+	case len(parts) == 3 && parts[0] == "athletes" && parts[2] == "routes":
+		s.listRoutes(w, id, r.URL.Query())
+	default:
+		writeFault(w, http.StatusNotFound, "Resource Not Found", "resource", "path", "invalid")
+	}
+}
+
+// listActivities serves the athlete's activities that started between the before and after
+// parameters, newest first. As on Strava, they are oldest first if only after is given.
+func (s *Server) listActivities(w http.ResponseWriter, query url.Values) {
+	before, ok := timeParam(w, query, "before")
+	if !ok {
+		return
+	}
+	after, ok := timeParam(w, query, "after")
+	if !ok {
+		return
+	}
+
+	var activities []stravahelpers.SummaryActivity
+	for _, activity := range s.fixtures.Activities {
+		if activity.Athlete.ID != s.fixtures.Athlete.ID {
+			continue
+		}
+		if !before.IsZero() && !activity.StartDate.Before(before) {
+			continue
+		}
+		if !after.IsZero() && !activity.StartDate.After(after) {
+			continue
+		}
+		activities = append(activities, activity.SummaryActivity)
+	}
+	ascending := !after.IsZero() && before.IsZero()
+	sort.SliceStable(activities, func(i, j int) bool {
+		if ascending {
+			return activities[i].StartDate.Before(activities[j].StartDate)
+		}
+		return activities[i].StartDate.After(activities[j].StartDate)
+	})
+
+	start, end, ok := pageParams(w, query, len(activities))
+	if !ok {
+		return
+	}
+	writeJSON(w, append([]stravahelpers.SummaryActivity{}, activities[start:end]...))
+}
+
+// getActivity serves one of the athlete's activities.
+func (s *Server) getActivity(w http.ResponseWriter, id int64) {
+	activity, ok := s.activityLocked(id)
+	if !ok {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Activity", "id", "not found")
+		return
+	}
+	writeJSON(w, activity)
+}
+
+// activityLocked returns the athlete's activity with the id. mu must be held.
+func (s *Server) activityLocked(id int64) (stravahelpers.DetailedActivity, bool) {
+	for _, activity := range s.fixtures.Activities {
+		if activity.ID == id && activity.Athlete.ID == s.fixtures.Athlete.ID {
+			return activity, true
+		}
+	}
+	return stravahelpers.DetailedActivity{}, false
+}
+
+// getStreams serves the streams of an activity named in the keys parameter, along with the distance
+// stream, which Strava always includes. With key_by_type they are an object keyed by the stream
+// type, otherwise they are a list with the type in each stream.
+func (s *Server) getStreams(w http.ResponseWriter, id int64, query url.Values) {
+	if _, ok := s.activityLocked(id); !ok {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Activity", "id", "not found")
+		return
+	}
+
+	// the StreamSet is already keyed by type once it is marshalled
+	var all map[string]map[string]interface{}
+	data, _ := json.Marshal(s.fixtures.Streams[id])
+	json.Unmarshal(data, &all)
+
+	wanted := map[string]bool{stravahelpers.StreamDistance: true}
+	for _, key := range strings.Split(query.Get("keys"), ",") {
+		wanted[strings.TrimSpace(key)] = true
+	}
+	keyByType := query.Get("key_by_type") == "true"
+	byType := map[string]interface{}{}
+	list := []interface{}{}
+	for _, key := range []string{stravahelpers.StreamTime, stravahelpers.StreamDistance, stravahelpers.StreamLatLng,
+		stravahelpers.StreamAltitude, stravahelpers.StreamVelocitySmooth, stravahelpers.StreamHeartrate,
+		stravahelpers.StreamCadence, stravahelpers.StreamWatts, stravahelpers.StreamTemp,
+		stravahelpers.StreamMoving, stravahelpers.StreamGradeSmooth} {
+		stream := all[key]
+		if stream == nil || !wanted[key] {
+			continue
+		}
+		if keyByType {
+			byType[key] = stream
+			continue
+		}
+		stream["type"] = key
+		list = append(list, stream)
+	}
+
+	if keyByType {
+		writeJSON(w, byType)
+		return
+	}
+	writeJSON(w, list)
+}
+
+// listClub serves a page of a club's members or activities.
+func (s *Server) listClub(w http.ResponseWriter, id int64, list string, query url.Values) {
+	club, ok := s.fixtures.Clubs[id]
+	if !ok {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Club", "id", "not found")
+		return
+	}
+	if list == "members" {
+		start, end, ok := pageParams(w, query, len(club.Members))
+		if ok {
+			writeJSON(w, append([]stravahelpers.ClubAthlete{}, club.Members[start:end]...))
+		}
+		return
+	}
+	start, end, ok := pageParams(w, query, len(club.Activities))
+	if ok {
+		writeJSON(w, append([]stravahelpers.ClubActivity{}, club.Activities[start:end]...))
+	}
+}
+
+// getStats serves the athlete's stats, which Strava only gives for the authenticated athlete.
+func (s *Server) getStats(w http.ResponseWriter, id int64) {
+	if id != s.fixtures.Athlete.ID {
+		writeFault(w, http.StatusForbidden, "Authorization Error", "Athlete", "id", "forbidden")
+		return
+	}
+	if len(s.fixtures.Stats) > 0 {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(s.fixtures.Stats)
+		return
+	}
+
+	var stats activityStats
+	now := time.Now()
+	yearStart := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, detailed := range s.fixtures.Activities {
+		activity := detailed.SummaryActivity
+		if activity.Athlete.ID != id {
+			continue
+		}
+		var recent, ytd, all *activityTotal
+		switch {
+		case activity.IsRide():
+			recent, ytd, all = &stats.RecentRideTotals, &stats.YTDRideTotals, &stats.AllRideTotals
+			if activity.Distance > stats.BiggestRideDistance {
+				stats.BiggestRideDistance = activity.Distance
+			}
+			if activity.TotalElevationGain > stats.BiggestClimbElevationGain {
+				stats.BiggestClimbElevationGain = activity.TotalElevationGain
+			}
+		case activity.Type == "Run":
+			recent, ytd, all = &stats.RecentRunTotals, &stats.YTDRunTotals, &stats.AllRunTotals
+		case activity.Type == "Swim":
+			recent, ytd, all = &stats.RecentSwimTotals, &stats.YTDSwimTotals, &stats.AllSwimTotals
+		default:
+			continue
+		}
+		all.add(activity)
+		if !activity.StartDate.Before(yearStart) {
+			ytd.add(activity)
+		}
+		if now.Sub(activity.StartDate) <= recentWindow {
+			recent.add(activity)
+		}
+	}
+	writeJSON(w, stats)
+}
+
+// listRoutes serves a page of an athlete's routes.
+func (s *Server) listRoutes(w http.ResponseWriter, id int64, query url.Values) {
+	var routes []stravahelpers.Route
+	for _, route := range s.fixtures.Routes {
+		athleteID := route.Athlete.ID
+		if athleteID == 0 {
+			athleteID = s.fixtures.Athlete.ID
+		}
+		if athleteID == id {
+			routes = append(routes, route)
+		}
+	}
+	start, end, ok := pageParams(w, query, len(routes))
+	if ok {
+		writeJSON(w, append([]stravahelpers.Route{}, routes[start:end]...))
+	}
+}
+
+// timeParam parses a parameter given in seconds since epoch. It returns the zero time if the
+// parameter isn't given, and false after writing a fault if it is invalid.
+func timeParam(w http.ResponseWriter, query url.Values, name string) (time.Time, bool) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, true
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", name, "invalid")
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// pageParams returns the range of a list of total items that is on the page given by the page and
+// per_page parameters. It returns false after writing a fault if they are invalid.
+func pageParams(w http.ResponseWriter, query url.Values, total int) (int, int, bool) {
+	page, perPage := 1, defaultPerPage
+	var err error
+	if value := query.Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "page", "invalid")
+			return 0, 0, false
+		}
+	}
+	if value := query.Get("per_page"); value != "" {
+		perPage, err = strconv.Atoi(value)
+		if err != nil || perPage < 1 {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "per_page", "invalid")
+			return 0, 0, false
+		}
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	start := (page - 1) * perPage
+	if start > total {
+		start = total
+	}
+	end := start + perPage
+	if end > total {
+		end = total
+	}
+	return start, end, true
+}
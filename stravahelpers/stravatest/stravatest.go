@@ -0,0 +1,368 @@
+// Package stravatest provides a fake Strava server for testing code that uses stravahelpers without
+// going to the network. The Server emulates the OAuth token exchange and refresh, and the athlete,
+// activity, stream, club, route and stats APIs, serving the activities and other resources in its
+// Fixtures. Errors, 429 - Too Many Requests and latency can be injected to test how callers cope.
+//
+// A test creates a Server, then a Client that talks to it:
+//
+//	server := stravatest.NewServer(fixtures)
+//	defer server.Close()
+//	client := stravahelpers.NewClient(append(server.ClientOptions(),
+//		stravahelpers.WithTokenSource(stravahelpers.StaticToken(server.IssueTokens().AccessToken)))...)
+package stravatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/stravahelpers"
+)
+
+// The API application the Server accepts OAuth calls from, and the authorization code it issues.
+const (
+	ClientID     = 1234
+	ClientSecret = "stravatest-secret"
+	AuthCode     = "stravatest-code"
+)
+
+// DefaultAthleteID is the id of the authenticated athlete when the fixtures don't give one.
+const DefaultAthleteID = 1001
+
+// TokenLifetime is how long the access tokens the Server issues are valid for, the same as Strava's.
+const TokenLifetime = 6 * time.Hour
+
+// The paths the Server serves the APIs and OAuth endpoints on.
+const (
+	apiPath   = "/api/v3/"
+	oauthPath = "/oauth/"
+)
+
+// The default quotas reported in the rate limit headers, the same as a new Strava API application.
+const (
+	DefaultShortTermLimit = 200
+	DefaultDailyLimit     = 2000
+)
+
+// Fixtures are the resources the Server serves. They can be built in the test, or loaded from a json
+// file with LoadFixtures. Activities and routes without an athlete belong to the authenticated
+// athlete.
+type Fixtures struct {
+	Athlete    stravahelpers.SummaryAthlete      `json:"athlete"`
+	Activities []stravahelpers.DetailedActivity  `json:"activities"`
+	Streams    map[int64]stravahelpers.StreamSet `json:"streams"` // keyed by activity id
+	Clubs      map[int64]Club                    `json:"clubs"`   // keyed by club id
+	Routes     []stravahelpers.Route             `json:"routes"`
+	// Stats is served as the athlete's stats if it is set, otherwise they are totalled from the
+	// activities.
+	Stats json.RawMessage `json:"stats"`
+}
+
+// Club is a club in the Fixtures, with its members and their activities.
+type Club struct {
+	Members    []stravahelpers.ClubAthlete  `json:"members"`
+	Activities []stravahelpers.ClubActivity `json:"activities"`
+}
+
+// LoadFixtures reads Fixtures from a json file.
+func LoadFixtures(path string) (Fixtures, error) {
+	var fixtures Fixtures
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fixtures, err
+	}
+	err = json.Unmarshal(data, &fixtures)
+	if err != nil {
+		return fixtures, fmt.Errorf("Unable to parse the fixtures in %s: %s", path, err)
+	}
+	return fixtures, nil
+}
+
+// Request is a request the Server received. Path is relative to BaseURL for API calls, such as
+// athlete/activities/, and starts with oauth/ for the OAuth endpoints.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+}
+
+// fault is an injected error, returned for the next times requests whose path starts with prefix.
+type fault struct {
+	prefix string
+	status int
+	times  int
+}
+
+// Server is a fake Strava, listening on a local port. Create one with NewServer and Close it when the
+// test is done. A Server is safe to use from multiple goroutines, and its fixtures and injected
+// errors can be changed while it is serving.
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	fixtures      Fixtures
+	accessTokens  map[string]time.Time // the valid access tokens, and when they expire
+	refreshTokens map[string]bool      // the valid refresh tokens
+	usedCode      bool
+	issued        int
+	faults        []*fault
+	latency       time.Duration
+	shortLimit    int
+	dailyLimit    int
+	shortUsage    int
+	dailyUsage    int
+	requests      []Request
+}
+
+// NewServer starts a Server serving the fixtures.
+func NewServer(fixtures Fixtures) *Server {
+	if fixtures.Athlete.ID == 0 {
+		fixtures.Athlete.ID = DefaultAthleteID
+	}
+	activities := fixtures.Activities
+	fixtures.Activities = nil // copied by SetActivities, so the caller's slice isn't changed
+	s := &Server{
+		fixtures:      fixtures,
+		accessTokens:  map[string]time.Time{},
+		refreshTokens: map[string]bool{},
+		shortLimit:    DefaultShortTermLimit,
+		dailyLimit:    DefaultDailyLimit,
+	}
+	s.SetActivities(activities...)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oauthPath+"authorize", s.handleAuthorize)
+	mux.HandleFunc(oauthPath+"token", s.handleToken)
+	mux.HandleFunc(oauthPath+"deauthorize", s.handleDeauthorize)
+	mux.HandleFunc(apiPath, s.handleAPI)
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// BaseURL returns the URL of the Server's APIs, for WithBaseURL.
+func (s *Server) BaseURL() string {
+	return s.URL + apiPath
+}
+
+// OAuthURL returns the URL of the Server's OAuth endpoints, for WithOAuthURL.
+func (s *Server) OAuthURL() string {
+	return s.URL + oauthPath
+}
+
+// ClientOptions returns the options for a Client that talks to the Server. Retries are made without
+// the usual backoff so that tests with injected errors don't wait.
+func (s *Server) ClientOptions() []stravahelpers.Option {
+	return []stravahelpers.Option{
+		stravahelpers.WithBaseURL(s.BaseURL()),
+		stravahelpers.WithOAuthURL(s.OAuthURL()),
+		stravahelpers.WithRetryPolicy(stravahelpers.RetryPolicy{
+			MaxAttempts: stravahelpers.DefaultRetryPolicy.MaxAttempts,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    10 * time.Millisecond,
+		}),
+	}
+}
+
+// WriteSecretsFile writes an API application secrets file for the Server's ClientID and
+// ClientSecret, for WithSecretsFile.
+func (s *Server) WriteSecretsFile(path string) error {
+	data, err := json.Marshal(map[string]interface{}{"ClientID": ClientID, "ClientSecret": ClientSecret})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// IssueTokens returns new tokens for the athlete, as if they had authorized the application to read
+// everything.
+func (s *Server) IssueTokens() stravahelpers.Tokens {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := s.issueLocked()
+	tokens.Scopes = []stravahelpers.Scope{stravahelpers.ScopeRead, stravahelpers.ScopeReadAll,
+		stravahelpers.ScopeProfileReadAll, stravahelpers.ScopeActivityReadAll}
+	return tokens
+}
+
+// issueLocked creates a new access and refresh token. mu must be held.
+func (s *Server) issueLocked() stravahelpers.Tokens {
+	s.issued++
+	expiresAt := time.Now().Add(TokenLifetime)
+	tokens := stravahelpers.Tokens{
+		AccessToken:  fmt.Sprintf("access-%d", s.issued),
+		RefreshToken: fmt.Sprintf("refresh-%d", s.issued),
+		ExpiresAt:    expiresAt.Unix(),
+		AthleteID:    s.fixtures.Athlete.ID,
+	}
+	s.accessTokens[tokens.AccessToken] = expiresAt
+	s.refreshTokens[tokens.RefreshToken] = true
+	return tokens
+}
+
+// ExpireTokens makes every access token issued so far expire, so the Server rejects them until they
+// are refreshed. The refresh tokens stay valid.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token := range s.accessTokens {
+		s.accessTokens[token] = time.Now()
+	}
+}
+
+// SetActivities adds the activities to the fixtures, replacing any with the same id.
+func (s *Server) SetActivities(activities ...stravahelpers.DetailedActivity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, activity := range activities {
+		if activity.Athlete.ID == 0 {
+			activity.Athlete.ID = s.fixtures.Athlete.ID
+		}
+		replaced := false
+		for i := range s.fixtures.Activities {
+			if s.fixtures.Activities[i].ID == activity.ID {
+				s.fixtures.Activities[i], replaced = activity, true
+			}
+		}
+		if !replaced {
+			s.fixtures.Activities = append(s.fixtures.Activities, activity)
+		}
+	}
+}
+
+// DeleteActivity removes the activity from the fixtures, as if the athlete deleted it on Strava.
+func (s *Server) DeleteActivity(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, activity := range s.fixtures.Activities {
+		if activity.ID == id {
+			s.fixtures.Activities = append(s.fixtures.Activities[:i], s.fixtures.Activities[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetStreams sets the streams of an activity.
+func (s *Server) SetStreams(activityID int64, streams stravahelpers.StreamSet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fixtures.Streams == nil {
+		s.fixtures.Streams = map[int64]stravahelpers.StreamSet{}
+	}
+	s.fixtures.Streams[activityID] = streams
+}
+
+// Fail makes the next times requests whose path starts with prefix fail with the status, and a
+// Strava fault in the body. The path is relative to BaseURL, such as athlete/activities, or
+// oauth/token for the OAuth endpoints. An empty prefix matches every request. Failing with
+// http.StatusTooManyRequests looks like an exhausted quota to the Client, which waits for the next
+// quarter hour unless it was created with RateLimitFail.
+func (s *Server) Fail(prefix string, status int, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault{prefix: strings.TrimPrefix(prefix, "/"), status: status, times: times})
+}
+
+// SetLatency delays every response by d, to test timeouts and cancellation.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetRateLimit sets the short term and daily quotas reported in the X-RateLimit headers. Every API
+// call counts against them, and calls once either is used up get a 429 - Too Many Requests. The
+// quotas default to DefaultShortTermLimit and DefaultDailyLimit.
+func (s *Server) SetRateLimit(shortTerm, daily int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shortLimit, s.dailyLimit = shortTerm, daily
+}
+
+// ResetUsage resets the usage of the quotas, as Strava does on the quarter hour and at midnight.
+func (s *Server) ResetUsage() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shortUsage, s.dailyUsage = 0, 0
+}
+
+// Requests returns the requests the Server has received, in the order they arrived.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// middleware records each request, and applies the latency, quotas and injected faults before
+// passing it on to next.
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, apiPath)
+		isAPI := path != r.URL.Path
+		if !isAPI {
+			path = strings.TrimPrefix(r.URL.Path, "/")
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: path, Query: r.URL.Query()})
+		latency := s.latency
+		status := 0
+		for _, f := range s.faults {
+			if f.times > 0 && strings.HasPrefix(path, f.prefix) {
+				f.times--
+				status = f.status
+				break
+			}
+		}
+		if isAPI {
+			s.shortUsage++
+			s.dailyUsage++
+			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d,%d", s.shortLimit, s.dailyLimit))
+			w.Header().Set("X-RateLimit-Usage", fmt.Sprintf("%d,%d", s.shortUsage, s.dailyUsage))
+			if status == 0 && (s.shortUsage > s.shortLimit || s.dailyUsage > s.dailyLimit) {
+				status = http.StatusTooManyRequests
+			}
+		}
+		s.mu.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if status != 0 {
+			writeFault(w, status, http.StatusText(status), "Application", "", "injected")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// faultBody is the body of a Strava error response.
+type faultBody struct {
+	Message string                     `json:"message"`
+	Errors  []stravahelpers.FieldError `json:"errors"`
+}
+
+// writeJSON writes v as the json body of a 200 - OK response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeFault writes a Strava fault, {"message": ..., "errors": [...]}, with the status.
+func writeFault(w http.ResponseWriter, status int, message, resource, field, code string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(faultBody{
+		Message: message,
+		Errors:  []stravahelpers.FieldError{{Resource: resource, Field: field, Code: code}},
+	})
+}
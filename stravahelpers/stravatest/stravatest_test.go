@@ -0,0 +1,244 @@
+package stravatest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/stravahelpers"
+)
+
+// testFixtures is an athlete with five rides a day apart in March 2021, the last of which has
+// streams, and a club with three members.
+func testFixtures() Fixtures {
+	fixtures := Fixtures{
+		Athlete: stravahelpers.SummaryAthlete{ID: 42, Firstname: "Test"},
+		Streams: map[int64]stravahelpers.StreamSet{5: {
+			Time:     &stravahelpers.Stream[int]{OriginalSize: 3, SeriesType: "distance", Data: []int{0, 10, 20}},
+			Distance: &stravahelpers.Stream[float64]{OriginalSize: 3, SeriesType: "distance", Data: []float64{0, 100, 200}},
+			Altitude: &stravahelpers.Stream[float64]{OriginalSize: 3, SeriesType: "distance", Data: []float64{10, 12, 11}},
+		}},
+		Clubs: map[int64]Club{7: {Members: []stravahelpers.ClubAthlete{{Firstname: "A"}, {Firstname: "B"}, {Firstname: "C"}}}},
+	}
+	for i := int64(1); i <= 5; i++ {
+		activity := stravahelpers.DetailedActivity{Description: "ride"}
+		activity.ID = i
+		activity.Type = "Ride"
+		activity.Distance = float64(i) * 1000
+		activity.Commute = i%2 == 1
+		activity.StartDate = time.Date(2021, 3, int(i), 16, 0, 0, 0, time.UTC)
+		fixtures.Activities = append(fixtures.Activities, activity)
+	}
+	return fixtures
+}
+
+// newClient returns a Client for the server with a valid access token, and any further options.
+func newClient(server *Server, opts ...stravahelpers.Option) *stravahelpers.Client {
+	opts = append(server.ClientOptions(), append([]stravahelpers.Option{
+		stravahelpers.WithTokenSource(stravahelpers.StaticToken(server.IssueTokens().AccessToken))}, opts...)...)
+	return stravahelpers.NewClient(opts...)
+}
+
+// TestActivities pages through the activities with before and after, and gets an activity and its
+// streams.
+func TestActivities(t *testing.T) {
+	server := NewServer(testFixtures())
+	defer server.Close()
+	client := newClient(server)
+	ctx := context.Background()
+
+	var ids []int64
+	after := time.Date(2021, 3, 1, 16, 0, 0, 0, time.UTC)
+	err := client.ActivityPages(ctx, stravahelpers.Params{}.After(after), stravahelpers.PageOptions{PerPage: 2},
+		func(page []stravahelpers.SummaryActivity) error {
+			for _, activity := range page {
+				ids = append(ids, activity.ID)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first ride started at after, so isn't included, and the rest are oldest first
+	if len(ids) != 4 || ids[0] != 2 || ids[3] != 5 {
+		t.Errorf("expected activities 2 to 5, got %v", ids)
+	}
+	// three pages of two, the last of which is empty
+	if pages := len(server.Requests()); pages != 3 {
+		t.Errorf("expected 3 requests, got %d", pages)
+	}
+
+	activities, err := client.ListActivities(ctx, stravahelpers.Params{}.Before(time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 3 || activities[0].ID != 3 || activities[0].Athlete.ID != 42 {
+		t.Errorf("expected activities 3 to 1, newest first, got %+v", activities)
+	}
+
+	activity, err := client.GetActivity(ctx, 5)
+	if err != nil || activity.Description != "ride" || activity.Distance != 5000 {
+		t.Errorf("unexpected activity %+v: %v", activity, err)
+	}
+	_, err = client.GetActivity(ctx, 6)
+	if !stravahelpers.IsNotFound(err) {
+		t.Errorf("expected a 404 for a missing activity, got %v", err)
+	}
+
+	streams, err := client.GetActivityStreams(ctx, 5, stravahelpers.StreamAltitude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streams.Altitude == nil || len(streams.Altitude.Data) != 3 || streams.Distance == nil || streams.Time != nil {
+		t.Errorf("expected the altitude and distance streams, got %+v", streams)
+	}
+}
+
+// TestClubsAndStats pages through a club's members and gets the athlete's stats.
+func TestClubsAndStats(t *testing.T) {
+	server := NewServer(testFixtures())
+	defer server.Close()
+	client := newClient(server)
+	ctx := context.Background()
+
+	members := 0
+	err := client.ClubMemberPages(ctx, 7, stravahelpers.PageOptions{PerPage: 2}, func(page []stravahelpers.ClubAthlete) error {
+		members += len(page)
+		return nil
+	})
+	if err != nil || members != 3 {
+		t.Errorf("expected 3 members, got %d: %v", members, err)
+	}
+
+	stats, err := client.GetJSON(ctx, "athletes/42/stats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals, _ := stats["all_ride_totals"].(map[string]interface{})
+	if totals["count"] != 5.0 || totals["distance"] != 15000.0 || stats["biggest_ride_distance"] != 5000.0 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+	_, err = client.GetJSON(ctx, "athletes/43/stats", nil)
+	if !stravahelpers.IsForbidden(err) {
+		t.Errorf("expected a 403 for another athlete's stats, got %v", err)
+	}
+}
+
+// TestOAuth authorizes, authenticates with expired tokens that are refreshed, has a token rejected and
+// refreshed again, then deauthorizes.
+func TestOAuth(t *testing.T) {
+	server := NewServer(testFixtures())
+	defer server.Close()
+	dir := t.TempDir()
+
+	// the authorize page redirects straight back with the code
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirects.Get(server.OAuthURL() + "authorize?" + url.Values{"client_id": {"1234"}, "response_type": {"code"},
+		"redirect_uri": {"http://localhost/exchange_token"}, "scope": {"activity:read_all"}, "state": {"s"}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if query := location.Query(); query.Get("code") != AuthCode || query.Get("state") != "s" || query.Get("scope") != "read,activity:read_all" {
+		t.Errorf("unexpected redirect: %s", location)
+	}
+
+	secretsPath := filepath.Join(dir, "secrets.json")
+	server.WriteSecretsFile(secretsPath)
+	store := stravahelpers.FileTokenStore{Path: filepath.Join(dir, "tokens.json")}
+	tokens := server.IssueTokens()
+	server.ExpireTokens()
+	tokens.ExpiresAt = time.Now().Unix()
+	store.Save(tokens)
+
+	client := stravahelpers.NewClient(append(server.ClientOptions(),
+		stravahelpers.WithSecretsFile(secretsPath), stravahelpers.WithTokenStore(store))...)
+	err = client.Authenticate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, _ := store.Load()
+	if refreshed.RefreshToken == tokens.RefreshToken || client.AthleteID() != 42 {
+		t.Errorf("expected the rotated tokens to be stored, got %+v", refreshed)
+	}
+
+	// a token that is rejected before it should expire is refreshed
+	server.ExpireTokens()
+	if _, err := client.GetAthlete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := store.Load()
+	err = client.Deauthorize(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.Path); !os.IsNotExist(err) {
+		t.Errorf("expected the tokens to be deleted, got %v", err)
+	}
+	revoked := stravahelpers.NewClient(append(server.ClientOptions(),
+		stravahelpers.WithTokenSource(stravahelpers.StaticToken(current.AccessToken)))...)
+	if _, err := revoked.GetAthlete(context.Background()); !stravahelpers.IsUnauthorized(err) {
+		t.Errorf("expected revoked tokens to be rejected, got %v", err)
+	}
+}
+
+// TestInjectedFaults checks that injected errors, 429s and latency reach the Client.
+func TestInjectedFaults(t *testing.T) {
+	server := NewServer(testFixtures())
+	defer server.Close()
+	client := newClient(server, stravahelpers.WithRateLimitPolicy(stravahelpers.RateLimitFail))
+	ctx := context.Background()
+
+	server.Fail(stravahelpers.StravaListActivitiesPath, http.StatusServiceUnavailable, 2)
+	if _, err := client.ListActivities(ctx, nil); err != nil {
+		t.Errorf("expected the call to succeed after retrying, got %v", err)
+	}
+	if requests := len(server.Requests()); requests != 3 {
+		t.Errorf("expected 2 retries, got %d requests", requests)
+	}
+
+	server.Fail("athlete", http.StatusTooManyRequests, 1)
+	if _, err := client.GetAthlete(ctx); !errors.Is(err, stravahelpers.ErrRateLimited) {
+		t.Errorf("expected the rate limit to be exceeded, got %v", err)
+	}
+	if usage := client.RateLimit(); usage.ShortTermLimit != DefaultShortTermLimit || usage.ShortTermUsage != 4 {
+		t.Errorf("unexpected rate limit usage: %+v", usage)
+	}
+
+	server.SetLatency(50 * time.Millisecond)
+	slow := newClient(server, stravahelpers.WithTimeout(10*time.Millisecond),
+		stravahelpers.WithRetryPolicy(stravahelpers.RetryPolicy{MaxAttempts: 1}))
+	if _, err := slow.GetAthlete(ctx); err == nil {
+		t.Error("expected the call to time out")
+	}
+}
+
+// TestLoadFixtures reads fixtures from a json file.
+func TestLoadFixtures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	os.WriteFile(path, []byte(`{"athlete": {"id": 7}, "activities": [{"id": 1, "type": "Ride", "start_date": "2021-03-01T16:00:00Z"}],
+		"stats": {"biggest_ride_distance": 1}}`), 0600)
+	fixtures, err := LoadFixtures(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(fixtures)
+	defer server.Close()
+	client := newClient(server)
+
+	activities, err := client.ListActivities(context.Background(), nil)
+	if err != nil || len(activities) != 1 || activities[0].Athlete.ID != 7 {
+		t.Errorf("unexpected activities %+v: %v", activities, err)
+	}
+	stats, err := client.GetJSON(context.Background(), "athletes/7/stats", nil)
+	if err != nil || stats["biggest_ride_distance"] != 1.0 {
+		t.Errorf("expected the stats from the fixtures, got %v: %v", stats, err)
+	}
+}
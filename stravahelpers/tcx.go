@@ -0,0 +1,68 @@
+package stravahelpers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// tcxFile is the part of a TCX file that ParseTCX reads.
+type tcxFile struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Notes string `xml:"Notes"`
+		Laps  []struct {
+			Tracks []struct {
+				Points []struct {
+					Time     string `xml:"Time"`
+					Position *struct {
+						Lat float64 `xml:"LatitudeDegrees"`
+						Lng float64 `xml:"LongitudeDegrees"`
+					} `xml:"Position"`
+					Altitude *float64 `xml:"AltitudeMeters"`
+					Distance *float64 `xml:"DistanceMeters"`
+				} `xml:"Trackpoint"`
+			} `xml:"Track"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+// ParseTCX parses a Garmin Training Center file. Only the first activity in the file is read.
+func ParseTCX(r io.Reader) (Track, error) {
+	var track Track
+	var tcx tcxFile
+	err := xml.NewDecoder(r).Decode(&tcx)
+	if err != nil {
+		return track, err
+	}
+	if len(tcx.Activities) == 0 {
+		return track, fmt.Errorf("no activities")
+	}
+
+	activity := tcx.Activities[0]
+	track.Name = activity.Notes
+	track.Type = sportType(activity.Sport)
+	for _, lap := range activity.Laps {
+		for _, trk := range lap.Tracks {
+			for _, trackpoint := range trk.Points {
+				pointTime, err := time.Parse(time.RFC3339, trackpoint.Time)
+				if err != nil {
+					return track, fmt.Errorf("trackpoint time: %s", err)
+				}
+				point := TrackPoint{Time: pointTime}
+				if trackpoint.Position != nil {
+					point.Lat, point.Lng, point.HasPosition = trackpoint.Position.Lat, trackpoint.Position.Lng, true
+				}
+				if trackpoint.Altitude != nil {
+					point.Elevation, point.HasElevation = *trackpoint.Altitude, true
+				}
+				if trackpoint.Distance != nil {
+					point.Distance = *trackpoint.Distance
+				}
+				track.Points = append(track.Points, point)
+			}
+		}
+	}
+	return track, nil
+}
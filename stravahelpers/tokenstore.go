@@ -0,0 +1,369 @@
+package stravahelpers
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Tokens are the OAuth tokens that authorize the application to act for an athlete.
+type Tokens struct {
+	AuthCode     string
+	RefreshToken string
+	AccessToken  string
+	ExpiresAt    int64   // when the access token expires, in seconds since epoch
+	AthleteID    int64   // the athlete that authorized the application, 0 if it is not known
+	Scopes       []Scope // the scopes the athlete granted, nil if they are not known
+}
+
+// ErrNoTokens is returned by a TokenStore that has no tokens stored, meaning the athlete needs to
+// authorize the application.
+var ErrNoTokens = errors.New("no stored tokens")
+
+// TokenStore keeps an athlete's tokens between runs. Strava rotates the refresh token, so Save is
+// called every time the tokens are refreshed.
+type TokenStore interface {
+	// Load returns the stored tokens, or ErrNoTokens if there are none.
+	Load() (Tokens, error)
+	// Save replaces the stored tokens.
+	Save(auth Tokens) error
+	// Delete removes the stored tokens. Deleting tokens that don't exist is not an error.
+	Delete() error
+}
+
+// WithTokenStore sets where Authenticate reads and stores the athlete's tokens. It defaults to a
+// FileTokenStore at ./tokens.json.
+func WithTokenStore(store TokenStore) Option {
+	return func(c *Client) {
+		c.tokenStore = store
+	}
+}
+
+// NewTokenStore returns the TokenStore named by kind, which is one of file, encrypted or env, so that
+// applications can choose the store from a command line flag. path is the file for the file and
+// encrypted stores, and the optional OutputPath of the env store, which can't be named like the
+// other stores' files. passphrase is only used by the encrypted store.
+func NewTokenStore(kind, path, passphrase string) (TokenStore, error) {
+	switch kind {
+	case "file":
+		return FileTokenStore{Path: path}, nil
+	case "encrypted":
+		if passphrase == "" {
+			return nil, fmt.Errorf("the encrypted token store needs a passphrase")
+		}
+		return EncryptedFileTokenStore{Path: path, Passphrase: passphrase}, nil
+	case "env":
+		if name := filepath.Base(path); path != "" && (name == TokensFileName || name == EncryptedTokensFileName) {
+			return nil, fmt.Errorf("the env token store can't write its tokens to %s, which is where the file and encrypted stores keep theirs, use eg %s",
+				path, EnvTokensFileName)
+		}
+		return EnvTokenStore{OutputPath: path}, nil
+	}
+	return nil, fmt.Errorf("unknown token store %q, must be one of file, encrypted or env", kind)
+}
+
+// FileTokenStore keeps the tokens as plain json in a file that only the owner can read.
+type FileTokenStore struct {
+	Path string
+}
+
+// Load reads the tokens from the file.
+func (s FileTokenStore) Load() (Tokens, error) {
+	var auth Tokens
+	data, err := readTokenFile(s.Path)
+	if err != nil {
+		return auth, err
+	}
+
+	err = json.Unmarshal(data, &auth)
+	if err != nil {
+		return auth, fmt.Errorf("Unable to parse %s: %s", s.Path, err)
+	}
+	return auth, nil
+}
+
+// Save writes the tokens to the file, replacing it atomically.
+func (s FileTokenStore) Save(auth Tokens) error {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, data)
+}
+
+// Delete removes the file.
+func (s FileTokenStore) Delete() error {
+	return removeIfExists(s.Path)
+}
+
+// scrypt parameters for deriving the key of an EncryptedFileTokenStore, as recommended by the scrypt
+// package for interactive logins.
+const (
+	scryptN       = 32768
+	scryptR       = 8
+	scryptP       = 1
+	scryptKeyLen  = 32 // AES-256
+	scryptSaltLen = 16
+)
+
+// encryptedTokens is the content of an EncryptedFileTokenStore file.
+type encryptedTokens struct {
+	Version    int
+	Salt       []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// EncryptedFileTokenStore keeps the tokens in a file encrypted with AES-GCM, using a key derived from
+// the passphrase with scrypt. A fresh salt and nonce are used every time the tokens are saved.
+type EncryptedFileTokenStore struct {
+	Path       string
+	Passphrase string
+}
+
+// Load reads and decrypts the tokens from the file.
+func (s EncryptedFileTokenStore) Load() (Tokens, error) {
+	var auth Tokens
+	data, err := readTokenFile(s.Path)
+	if err != nil {
+		return auth, err
+	}
+
+	var encrypted encryptedTokens
+	err = json.Unmarshal(data, &encrypted)
+	if err != nil {
+		return auth, fmt.Errorf("Unable to parse %s: %s", s.Path, err)
+	}
+	if encrypted.Version != 1 {
+		return auth, fmt.Errorf("Unsupported version %d of %s", encrypted.Version, s.Path)
+	}
+
+	gcm, err := s.cipher(encrypted.Salt)
+	if err != nil {
+		return auth, err
+	}
+	plaintext, err := gcm.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+	if err != nil {
+		return auth, fmt.Errorf("Unable to decrypt %s, the passphrase may be wrong: %s", s.Path, err)
+	}
+
+	err = json.Unmarshal(plaintext, &auth)
+	if err != nil {
+		return auth, fmt.Errorf("Unable to parse the decrypted %s: %s", s.Path, err)
+	}
+	return auth, nil
+}
+
+// Save encrypts the tokens and writes them to the file, replacing it atomically.
+func (s EncryptedFileTokenStore) Save(auth Tokens) error {
+	plaintext, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+
+	encrypted := encryptedTokens{
+		Version: 1,
+		Salt:    make([]byte, scryptSaltLen),
+	}
+	_, err = rand.Read(encrypted.Salt)
+	if err != nil {
+		return err
+	}
+	gcm, err := s.cipher(encrypted.Salt)
+	if err != nil {
+		return err
+	}
+	encrypted.Nonce = make([]byte, gcm.NonceSize())
+	_, err = rand.Read(encrypted.Nonce)
+	if err != nil {
+		return err
+	}
+	encrypted.Ciphertext = gcm.Seal(nil, encrypted.Nonce, plaintext, nil)
+
+	data, err := json.Marshal(encrypted)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, data)
+}
+
+// Delete removes the file.
+func (s EncryptedFileTokenStore) Delete() error {
+	return removeIfExists(s.Path)
+}
+
+// cipher derives the key from the passphrase and salt, and returns an AES-GCM cipher using it.
+func (s EncryptedFileTokenStore) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(s.Passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// The environment variables read by EnvTokenStore.
+const (
+	EnvAccessToken  = "STRAVA_ACCESS_TOKEN"
+	EnvRefreshToken = "STRAVA_REFRESH_TOKEN"
+	EnvExpiresAt    = "STRAVA_TOKEN_EXPIRES_AT"
+	EnvAthleteID    = "STRAVA_ATHLETE_ID"
+	EnvScopes       = "STRAVA_SCOPES"
+)
+
+// EnvTokenStore reads the tokens from environment variables, so they can be injected by a CI system
+// or secrets manager. Only STRAVA_REFRESH_TOKEN is required, the access token is refreshed if
+// STRAVA_ACCESS_TOKEN or STRAVA_TOKEN_EXPIRES_AT are missing.
+// Save can only update the environment of the running process, and Strava revokes the old refresh
+// token when it issues a new one. So when the refresh token changes, such as when Strava rotates it or
+// the athlete authorizes the application, a warning names the variables to update, and the new values
+// are written as NAME=value lines to OutputPath if there is one. Nothing else is written to disk, and
+// the values are never logged.
+type EnvTokenStore struct {
+	OutputPath string // file the variables are written to when the refresh token changes, readable only by the owner
+}
+
+// envTokenNotices is where EnvTokenStore says which variables to update, it never gets their values.
+var envTokenNotices io.Writer = os.Stderr
+
+// Load reads the tokens from the environment.
+func (s EnvTokenStore) Load() (Tokens, error) {
+	var auth Tokens
+	auth.RefreshToken = os.Getenv(EnvRefreshToken)
+	if auth.RefreshToken == "" {
+		return auth, ErrNoTokens
+	}
+	auth.AccessToken = os.Getenv(EnvAccessToken)
+
+	var err error
+	if value := os.Getenv(EnvExpiresAt); value != "" {
+		auth.ExpiresAt, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return auth, fmt.Errorf("%s must be seconds since epoch: %s", EnvExpiresAt, err)
+		}
+	}
+	if value := os.Getenv(EnvAthleteID); value != "" {
+		auth.AthleteID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return auth, fmt.Errorf("%s must be a number: %s", EnvAthleteID, err)
+		}
+	}
+	if value, ok := os.LookupEnv(EnvScopes); ok {
+		auth.Scopes = parseGrantedScopes(value)
+	}
+	return auth, nil
+}
+
+// Save updates the environment of the running process. If the refresh token changed, since the old
+// one no longer works, it says which variables to update and writes them to OutputPath if there is one.
+func (s EnvTokenStore) Save(auth Tokens) error {
+	rotated := os.Getenv(EnvRefreshToken) != auth.RefreshToken
+	os.Setenv(EnvRefreshToken, auth.RefreshToken)
+	os.Setenv(EnvAccessToken, auth.AccessToken)
+	os.Setenv(EnvExpiresAt, strconv.FormatInt(auth.ExpiresAt, 10))
+	if !rotated {
+		return nil
+	}
+
+	if s.OutputPath == "" {
+		notice := fmt.Sprintf("The Strava refresh token changed and the old one no longer works, update %s, %s and %s before the next run\n",
+			EnvRefreshToken, EnvAccessToken, EnvExpiresAt)
+		logger.WARN.Print(notice)
+		_, err := fmt.Fprint(envTokenNotices, notice)
+		return err
+	}
+
+	var lines bytes.Buffer
+	fmt.Fprintf(&lines, "%s=%s\n%s=%s\n%s=%d\n", EnvRefreshToken, auth.RefreshToken, EnvAccessToken, auth.AccessToken,
+		EnvExpiresAt, auth.ExpiresAt)
+	if auth.AthleteID != 0 {
+		fmt.Fprintf(&lines, "%s=%d\n", EnvAthleteID, auth.AthleteID)
+	}
+	if auth.Scopes != nil {
+		fmt.Fprintf(&lines, "%s=%s\n", EnvScopes, joinScopes(auth.Scopes))
+	}
+	notice := fmt.Sprintf("The Strava refresh token changed and the old one no longer works, update %s and the other variables from %s before the next run\n",
+		EnvRefreshToken, s.OutputPath)
+	logger.WARN.Print(notice)
+	err := writeFileAtomic(s.OutputPath, lines.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(envTokenNotices, notice)
+	return err
+}
+
+// Delete removes the tokens from the environment of the running process.
+func (s EnvTokenStore) Delete() error {
+	for _, name := range []string{EnvAccessToken, EnvRefreshToken, EnvExpiresAt, EnvAthleteID, EnvScopes} {
+		os.Unsetenv(name)
+	}
+	return nil
+}
+
+// readTokenFile reads a token file, returning ErrNoTokens if it does not exist.
+func readTokenFile(path string) ([]byte, error) {
+	fileInfo, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoTokens
+	} else if err != nil {
+		return nil, err
+	}
+	if fileInfo.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	if fileInfo.Mode().Perm()&0077 != 0 {
+		logger.WARN.Printf("%s can be read by other users, it should have mode 0600\n", path)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// writeFileAtomic writes the file readable only by the owner. The data is written to a temporary
+// file that replaces the original, so a crash part way through never leaves a truncated file.
+func writeFileAtomic(path string, data []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name()) // only succeeds if the rename didn't happen
+
+	err = temp.Chmod(0600)
+	if err == nil {
+		_, err = temp.Write(data)
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// removeIfExists removes the file, ignoring that it doesn't exist.
+func removeIfExists(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package stravahelpers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTokenStores saves and loads tokens with each of the stores, and checks that a missing store
+// reports ErrNoTokens.
+func TestTokenStores(t *testing.T) {
+	dir := t.TempDir()
+	auth := Tokens{RefreshToken: "refresh", AccessToken: "access", ExpiresAt: 1650000000, AthleteID: 42, Scopes: []Scope{ScopeRead}}
+
+	stores := map[string]TokenStore{
+		"file":      FileTokenStore{Path: filepath.Join(dir, "tokens.json")},
+		"encrypted": EncryptedFileTokenStore{Path: filepath.Join(dir, "tokens.enc"), Passphrase: "correct horse"},
+		"env":       EnvTokenStore{OutputPath: filepath.Join(dir, "tokens.env")},
+	}
+	for name, store := range stores {
+		_, err := store.Load()
+		if err != ErrNoTokens {
+			t.Errorf("%s: expected ErrNoTokens before saving, got %v", name, err)
+		}
+		err = store.Save(auth)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		loaded, err := store.Load()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if loaded.RefreshToken != auth.RefreshToken || loaded.AccessToken != auth.AccessToken || loaded.ExpiresAt != auth.ExpiresAt {
+			t.Errorf("%s: loaded %+v, expected %+v", name, loaded, auth)
+		}
+		err = store.Delete()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		_, err = store.Load()
+		if err != ErrNoTokens {
+			t.Errorf("%s: expected ErrNoTokens after deleting, got %v", name, err)
+		}
+	}
+}
+
+// TestTokenFilesAreProtected checks the token files can only be read by their owner, and the
+// encrypted file does not contain the tokens and can't be read with the wrong passphrase.
+func TestTokenFilesAreProtected(t *testing.T) {
+	dir := t.TempDir()
+	auth := Tokens{RefreshToken: "refresh-secret", AccessToken: "access-secret"}
+
+	plain := FileTokenStore{Path: filepath.Join(dir, "tokens.json")}
+	encrypted := EncryptedFileTokenStore{Path: filepath.Join(dir, "tokens.enc"), Passphrase: "correct horse"}
+	for _, store := range []TokenStore{plain, encrypted} {
+		if err := store.Save(auth); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{plain.Path, encrypted.Path} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s has mode %s, expected 0600", path, info.Mode().Perm())
+		}
+	}
+
+	data, _ := os.ReadFile(encrypted.Path)
+	if len(data) == 0 || strings.Contains(string(data), "refresh-secret") {
+		t.Errorf("the encrypted file contains the plaintext token: %s", data)
+	}
+	_, err := EncryptedFileTokenStore{Path: encrypted.Path, Passphrase: "wrong"}.Load()
+	if err == nil {
+		t.Error("expected the wrong passphrase to fail")
+	}
+}
+
+// TestEnvTokenRotation checks that when the refresh token changes, the env store writes out the new
+// variables rather than losing them with the process.
+func TestEnvTokenRotation(t *testing.T) {
+	t.Setenv(EnvRefreshToken, "old-refresh")
+	t.Setenv(EnvAccessToken, "old-access")
+	path := filepath.Join(t.TempDir(), "tokens.env")
+	store := EnvTokenStore{OutputPath: path}
+
+	// a refreshed access token with the same refresh token isn't written out
+	err := store.Save(Tokens{RefreshToken: "old-refresh", AccessToken: "new-access", ExpiresAt: 1650000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written, got %v", err)
+	}
+
+	err = store.Save(Tokens{RefreshToken: "new-refresh", AccessToken: "newer-access", ExpiresAt: 1650000001, AthleteID: 42,
+		Scopes: []Scope{ScopeRead, ScopeActivityReadAll}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "STRAVA_REFRESH_TOKEN=new-refresh\nSTRAVA_ACCESS_TOKEN=newer-access\nSTRAVA_TOKEN_EXPIRES_AT=1650000001\n" +
+		"STRAVA_ATHLETE_ID=42\nSTRAVA_SCOPES=read,activity:read_all\n"
+	if string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %s", info.Mode().Perm())
+	}
+	if os.Getenv(EnvRefreshToken) != "new-refresh" {
+		t.Errorf("expected the environment to be updated")
+	}
+
+	// without an output file only the names of the variables are given, never the tokens
+	var stderr strings.Builder
+	envTokenNotices = &stderr
+	defer func() { envTokenNotices = os.Stderr }()
+	err = EnvTokenStore{}.Save(Tokens{RefreshToken: "newest-refresh", AccessToken: "newest-access"})
+	if err != nil || !strings.Contains(stderr.String(), EnvRefreshToken) || strings.Contains(stderr.String(), "newest") {
+		t.Errorf("expected the variable to update without its value, got %q: %v", stderr.String(), err)
+	}
+
+	// the env store can't write over the file store's tokens
+	_, err = NewTokenStore("env", filepath.Join(t.TempDir(), TokensFileName), "")
+	if err == nil {
+		t.Errorf("expected the env store to refuse to write to %s", TokensFileName)
+	}
+}
@@ -0,0 +1,326 @@
+package stravahelpers
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/droppedbars/strava-commute-times/logger"
+)
+
+// Activities can be recorded on devices that never sync to Strava. The GPX, TCX and FIT files they
+// record are parsed into a Track, which becomes a SummaryActivity that can be stored along with the
+// activities from Strava.
+
+// TrackPoint is a single sample of a recorded activity.
+type TrackPoint struct {
+	Time         time.Time
+	Lat          float64 // degrees
+	Lng          float64 // degrees
+	HasPosition  bool
+	Elevation    float64 // meters
+	HasElevation bool
+	Distance     float64 // meters from the start as recorded by the device, 0 if it wasn't
+}
+
+// Track is an activity recorded in a GPX, TCX or FIT file.
+type Track struct {
+	Name   string
+	Type   string // activity type using Strava's names, eg Ride, empty if the file doesn't say
+	File   string // name of the file it was parsed from, without the directory, empty if not known
+	Points []TrackPoint
+}
+
+// LatLng is a position in degrees.
+type LatLng struct {
+	Lat float64
+	Lng float64
+}
+
+const (
+	earthRadius = 6371000.0 // meters
+	// movingSpeed is the speed in meters per second below which the athlete is treated as stopped
+	movingSpeed = 0.5
+	// maxMovingGap is the longest gap between samples that can count as moving. Devices stop
+	// recording when auto pause kicks in, so a longer gap is a stop.
+	maxMovingGap = 30 * time.Second
+	// elevationThreshold is how far the elevation must change before it counts, so that noise in
+	// the recorded elevation isn't counted as climbing.
+	elevationThreshold = 2.0
+)
+
+// TrackFileExtensions are the file extensions ParseTrackFile understands. Each can also be gzipped
+// with a further .gz, as they are in Strava exports.
+var TrackFileExtensions = []string{".gpx", ".tcx", ".fit"}
+
+// IsTrackFile returns true if the file's extension is one ParseTrackFile understands.
+func IsTrackFile(path string) bool {
+	return trackFileExtension(path) != ""
+}
+
+// trackFileExtension returns the extension of a track file, ignoring .gz, or "" if it isn't one.
+func trackFileExtension(path string) string {
+	ext := filepath.Ext(strings.TrimSuffix(strings.ToLower(path), ".gz"))
+	for _, known := range TrackFileExtensions {
+		if ext == known {
+			return ext
+		}
+	}
+	return ""
+}
+
+// ParseTrackFile parses a GPX, TCX or FIT file, chosen by its extension.
+func ParseTrackFile(path string) (Track, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Track{}, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return Track{}, fmt.Errorf("Unable to decompress %s: %s", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var track Track
+	switch trackFileExtension(path) {
+	case ".gpx":
+		track, err = ParseGPX(r)
+	case ".tcx":
+		track, err = ParseTCX(r)
+	case ".fit":
+		track, err = ParseFIT(r)
+	default:
+		return track, fmt.Errorf("%s is not a GPX, TCX or FIT file", path)
+	}
+	if err != nil {
+		return track, fmt.Errorf("Unable to parse %s: %s", path, err)
+	}
+	if len(track.Points) == 0 {
+		return track, fmt.Errorf("%s has no track points", path)
+	}
+	track.File = filepath.Base(path)
+	return track, nil
+}
+
+// Activity summarizes the track as an activity. The distance is computed from the positions, or
+// taken from the device's distance for tracks without positions, such as indoor rides. Moving time
+// leaves out the time spent stopped. Activities from files have no Strava id, so the id is made by
+// TrackActivityID from the start time. Use ActivityStore.ImportTracks to store them, which tells
+// apart different activities that started in the same second.
+func (t Track) Activity() SummaryActivity {
+	var activity SummaryActivity
+	if len(t.Points) == 0 {
+		return activity
+	}
+	first, last := t.Points[0], t.Points[len(t.Points)-1]
+	activity.ID = TrackActivityID(first.Time, 0)
+	activity.ExternalID = t.File
+	activity.Name = t.Name
+	activity.Type = t.Type
+	activity.SportType = t.Type
+	activity.StartDate = first.Time.UTC()
+	activity.ElapsedTime = int(last.Time.Sub(first.Time).Seconds())
+
+	var moving time.Duration
+	var previous *TrackPoint
+	for i := range t.Points {
+		point := &t.Points[i]
+		if previous != nil {
+			step := 0.0
+			if point.HasPosition && previous.HasPosition {
+				step = haversine(previous.Lat, previous.Lng, point.Lat, point.Lng)
+			} else if point.Distance > previous.Distance {
+				step = point.Distance - previous.Distance
+			}
+			activity.Distance += step
+
+			gap := point.Time.Sub(previous.Time)
+			if gap > 0 && gap <= maxMovingGap && step/gap.Seconds() >= movingSpeed {
+				moving += gap
+			}
+		}
+		if point.HasPosition || point.Distance > 0 {
+			previous = point
+		}
+	}
+	activity.MovingTime = int(moving.Seconds())
+	if activity.MovingTime > 0 {
+		activity.AverageSpeed = activity.Distance / float64(activity.MovingTime)
+	}
+	activity.TotalElevationGain, activity.ElevLow, activity.ElevHigh = elevation(t.Points)
+
+	for _, point := range t.Points {
+		if point.HasPosition {
+			activity.StartLatLng = []float64{point.Lat, point.Lng}
+			break
+		}
+	}
+	for i := len(t.Points) - 1; i >= 0; i-- {
+		if t.Points[i].HasPosition {
+			activity.EndLatLng = []float64{t.Points[i].Lat, t.Points[i].Lng}
+			break
+		}
+	}
+	return activity
+}
+
+// maxTracksPerSecond is how many different activities from files can start in the same second.
+const maxTracksPerSecond = 10
+
+// sameRideTolerance is how much, as a fraction of the longer one, the distances of two activities from
+// files that started in the same second can differ by and still be the same ride recorded twice.
+const sameRideTolerance = 0.05
+
+// TrackActivityID returns the id of the nth activity from a file that started at start, from 0 to
+// maxTracksPerSecond-1. It is negative, so it is never a Strava id.
+func TrackActivityID(start time.Time, n int) int64 {
+	return -(start.Unix()*maxTracksPerSecond + int64(n))
+}
+
+// ImportTracks imports activities from files, as made by Track.Activity, giving each an id from
+// TrackActivityID that isn't taken by a different activity. An activity from the same file as a
+// stored one, by ExternalID, replaces it. One that started in the same second as a stored activity
+// from another file, and has about the same distance, is the same ride recorded by another device or
+// a renamed copy of the file, so it is skipped rather than counted twice. It returns the activities
+// that were skipped.
+func (s *ActivityStore) ImportTracks(activities ...SummaryActivity) []SummaryActivity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var skipped []SummaryActivity
+	for _, activity := range activities {
+		stored := false
+		for n := 0; n < maxTracksPerSecond && !stored; n++ {
+			id := TrackActivityID(activity.StartDate, n)
+			existing, ok := s.data.Activities[id]
+			if ok && existing.ExternalID != activity.ExternalID {
+				if sameRide(existing, activity) {
+					logger.INFO.Printf("%s is the same ride as %s, which is already stored, so it is skipped\n", activity.ExternalID, existing.ExternalID)
+					skipped = append(skipped, activity)
+					stored = true
+				}
+				continue
+			}
+			activity.ID = id
+			s.data.Activities[id] = activity
+			s.data.Imported[id] = true
+			stored = true
+		}
+		if !stored {
+			logger.WARN.Printf("%s is skipped, %d other activities from files started at %s\n", activity.ExternalID, maxTracksPerSecond, activity.StartDate)
+			skipped = append(skipped, activity)
+		}
+	}
+	s.data.ImportedAt = time.Now()
+	return skipped
+}
+
+// sameRide returns true if the distances of the activities are within sameRideTolerance of each other.
+func sameRide(a, b SummaryActivity) bool {
+	return math.Abs(a.Distance-b.Distance) <= sameRideTolerance*math.Max(a.Distance, b.Distance)
+}
+
+// elevation returns the elevation gain, ignoring changes smaller than elevationThreshold, and the
+// lowest and highest elevations.
+func elevation(points []TrackPoint) (float64, float64, float64) {
+	gain, low, high := 0.0, math.Inf(1), math.Inf(-1)
+	reference := math.NaN()
+	for _, point := range points {
+		if !point.HasElevation {
+			continue
+		}
+		low = math.Min(low, point.Elevation)
+		high = math.Max(high, point.Elevation)
+		if math.IsNaN(reference) || point.Elevation < reference {
+			reference = point.Elevation
+		} else if point.Elevation-reference >= elevationThreshold {
+			gain += point.Elevation - reference
+			reference = point.Elevation
+		}
+	}
+	if math.IsInf(low, 1) {
+		return 0, 0, 0
+	}
+	return gain, low, high
+}
+
+// sportType maps the sport names used by GPX, TCX and FIT files onto Strava's activity types. It
+// returns "" for sports it doesn't know.
+func sportType(sport string) string {
+	sport = strings.ToLower(sport)
+	switch {
+	case strings.Contains(sport, "ebik") || strings.Contains(sport, "e_bik") || strings.Contains(sport, "e-bik"):
+		return "EBikeRide"
+	case strings.Contains(sport, "bik") || strings.Contains(sport, "cycl") || strings.Contains(sport, "ride"):
+		return "Ride"
+	case strings.Contains(sport, "run"):
+		return "Run"
+	case strings.Contains(sport, "hik"):
+		return "Hike"
+	case strings.Contains(sport, "walk"):
+		return "Walk"
+	}
+	return ""
+}
+
+// haversine returns the distance in meters between two positions.
+func haversine(lat1, lng1, lat2, lng2 float64) float64 {
+	toRadians := math.Pi / 180
+	dLat := (lat2 - lat1) * toRadians
+	dLng := (lng2 - lng1) * toRadians
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRadians)*math.Cos(lat2*toRadians)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// CommuteClassifier decides whether an activity from a file was a commute, since there is no
+// commute flag in GPX, TCX or FIT files.
+type CommuteClassifier struct {
+	// Places are where commutes start and end, such as home and work. An activity is a commute if
+	// it starts within Radius of one place and ends within Radius of another.
+	Places []LatLng
+	// Radius is in meters, defaulting to DefaultCommuteRadius.
+	Radius float64
+}
+
+// DefaultCommuteRadius is the CommuteClassifier Radius used when it isn't set.
+const DefaultCommuteRadius = 250.0
+
+// IsCommute returns true if the activity goes from one of the places to another, or its name says
+// it is a commute.
+func (c CommuteClassifier) IsCommute(activity SummaryActivity) bool {
+	if strings.Contains(strings.ToLower(activity.Name), "commute") {
+		return true
+	}
+	if len(activity.StartLatLng) != 2 || len(activity.EndLatLng) != 2 {
+		return false
+	}
+	start, end := c.nearest(activity.StartLatLng), c.nearest(activity.EndLatLng)
+	return start >= 0 && end >= 0 && start != end
+}
+
+// nearest returns the index of the place within Radius of the position, or -1 if there isn't one.
+func (c CommuteClassifier) nearest(position []float64) int {
+	radius := c.Radius
+	if radius <= 0 {
+		radius = DefaultCommuteRadius
+	}
+	found, foundDistance := -1, radius
+	for i, place := range c.Places {
+		distance := haversine(place.Lat, place.Lng, position[0], position[1])
+		if distance <= foundDistance {
+			found, foundDistance = i, distance
+		}
+	}
+	return found
+}
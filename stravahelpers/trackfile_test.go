@@ -0,0 +1,168 @@
+package stravahelpers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The same ride in each format: four samples 10 seconds and about 100m apart, and a last sample
+// 20 seconds later that didn't move.
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+ <trk><name>Morning Commute</name><type>cycling</type><trkseg>
+  <trkpt lat="49.2800" lon="-123.1200"><ele>10</ele><time>2021-03-01T16:00:00Z</time></trkpt>
+  <trkpt lat="49.2809" lon="-123.1200"><ele>11</ele><time>2021-03-01T16:00:10Z</time></trkpt>
+  <trkpt lat="49.2818" lon="-123.1200"><ele>15</ele><time>2021-03-01T16:00:20Z</time></trkpt>
+  <trkpt lat="49.2827" lon="-123.1200"><ele>14</ele><time>2021-03-01T16:00:30Z</time></trkpt>
+  <trkpt lat="49.2827" lon="-123.1200"><ele>14</ele><time>2021-03-01T16:00:50Z</time></trkpt>
+ </trkseg></trk>
+</gpx>`
+
+const testTCX = `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+ <Activities><Activity Sport="Biking"><Id>2021-03-01T16:00:00Z</Id><Lap StartTime="2021-03-01T16:00:00Z"><Track>
+  <Trackpoint><Time>2021-03-01T16:00:00Z</Time><Position><LatitudeDegrees>49.2800</LatitudeDegrees><LongitudeDegrees>-123.1200</LongitudeDegrees></Position><AltitudeMeters>10</AltitudeMeters></Trackpoint>
+  <Trackpoint><Time>2021-03-01T16:00:10Z</Time><Position><LatitudeDegrees>49.2809</LatitudeDegrees><LongitudeDegrees>-123.1200</LongitudeDegrees></Position><AltitudeMeters>11</AltitudeMeters></Trackpoint>
+  <Trackpoint><Time>2021-03-01T16:00:20Z</Time><Position><LatitudeDegrees>49.2818</LatitudeDegrees><LongitudeDegrees>-123.1200</LongitudeDegrees></Position><AltitudeMeters>15</AltitudeMeters></Trackpoint>
+  <Trackpoint><Time>2021-03-01T16:00:30Z</Time><Position><LatitudeDegrees>49.2827</LatitudeDegrees><LongitudeDegrees>-123.1200</LongitudeDegrees></Position><AltitudeMeters>14</AltitudeMeters></Trackpoint>
+  <Trackpoint><Time>2021-03-01T16:00:50Z</Time><Position><LatitudeDegrees>49.2827</LatitudeDegrees><LongitudeDegrees>-123.1200</LongitudeDegrees></Position><AltitudeMeters>14</AltitudeMeters></Trackpoint>
+ </Track></Lap></Activity></Activities>
+</TrainingCenterDatabase>`
+
+// testFIT encodes the same ride as a FIT file. The last record uses a compressed timestamp header.
+func testFIT() []byte {
+	var records bytes.Buffer
+	le := binary.LittleEndian
+	// definition of local message 0 as a record: timestamp, position_lat, position_long, altitude
+	records.Write([]byte{0x40, 0, 0})
+	binary.Write(&records, le, uint16(fitMesgRecord))
+	records.Write([]byte{4, 253, 4, 0x86, 0, 4, 0x85, 1, 4, 0x85, 2, 2, 0x84})
+	start := uint32(time.Date(2021, 3, 1, 16, 0, 0, 0, time.UTC).Unix() - fitEpoch)
+	semicircles := func(degrees float64) int32 { return int32(math.Round(degrees / fitSemicircle)) }
+	altitude := func(meters float64) uint16 { return uint16((meters + 500) * 5) }
+	lats := []float64{49.2800, 49.2809, 49.2818, 49.2827}
+	elevations := []float64{10, 11, 15, 14}
+	for i := range lats {
+		records.WriteByte(0x00)
+		binary.Write(&records, le, start+uint32(i*10))
+		binary.Write(&records, le, semicircles(lats[i]))
+		binary.Write(&records, le, semicircles(-123.12))
+		binary.Write(&records, le, altitude(elevations[i]))
+	}
+	// definition of local message 1 as a record without a timestamp, for the compressed header
+	records.Write([]byte{0x41, 0, 0})
+	binary.Write(&records, le, uint16(fitMesgRecord))
+	records.Write([]byte{3, 0, 4, 0x85, 1, 4, 0x85, 2, 2, 0x84})
+	records.WriteByte(0x80 | 1<<5 | byte((start+50)&0x1f))
+	binary.Write(&records, le, semicircles(49.2827))
+	binary.Write(&records, le, semicircles(-123.12))
+	binary.Write(&records, le, altitude(14))
+	// a session with the sport set to cycling
+	records.Write([]byte{0x42, 0, 0})
+	binary.Write(&records, le, uint16(fitMesgSession))
+	records.Write([]byte{1, 5, 1, 0x00, 0x02, 2})
+
+	var file bytes.Buffer
+	file.Write([]byte{12, 0x10})
+	binary.Write(&file, le, uint16(2100))
+	binary.Write(&file, le, uint32(records.Len()))
+	file.WriteString(".FIT")
+	file.Write(records.Bytes())
+	file.Write([]byte{0, 0}) // the CRC isn't checked
+	return file.Bytes()
+}
+
+// TestParseTrackFiles checks that each format gives the same activity, with the distance, times and
+// elevation computed from the samples.
+func TestParseTrackFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ride.gpx"), []byte(testGPX), 0600)
+	os.WriteFile(filepath.Join(dir, "ride.tcx"), []byte(testTCX), 0600)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(testFIT())
+	gz.Close()
+	os.WriteFile(filepath.Join(dir, "ride.fit.gz"), gzipped.Bytes(), 0600)
+
+	start := time.Date(2021, 3, 1, 16, 0, 0, 0, time.UTC)
+	for _, name := range []string{"ride.gpx", "ride.tcx", "ride.fit.gz"} {
+		track, err := ParseTrackFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		activity := track.Activity()
+		if activity.ID != TrackActivityID(start, 0) || activity.ExternalID != name || !activity.StartDate.Equal(start) || activity.Type != "Ride" {
+			t.Errorf("%s: unexpected activity: %+v", name, activity)
+		}
+		if math.Abs(activity.Distance-300) > 1 {
+			t.Errorf("%s: expected a distance of about 300m, got %.1f", name, activity.Distance)
+		}
+		if activity.ElapsedTime != 50 || activity.MovingTime != 30 {
+			t.Errorf("%s: expected 50s elapsed and 30s moving, got %d and %d", name, activity.ElapsedTime, activity.MovingTime)
+		}
+		if math.Abs(activity.TotalElevationGain-5) > 0.5 || math.Abs(activity.ElevHigh-15) > 0.5 {
+			t.Errorf("%s: expected 5m of climbing to 15m, got %.1f to %.1f", name, activity.TotalElevationGain, activity.ElevHigh)
+		}
+	}
+}
+
+// TestSameStartTracks checks that different rides from files that start in the same second are kept
+// apart, while the same ride from two devices or a renamed file is only stored once, and reading a
+// file again replaces it.
+func TestSameStartTracks(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenActivityStore(filepath.Join(dir, ActivitiesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 3, 1, 16, 0, 0, 0, time.UTC)
+	commute := func(file string, km float64) SummaryActivity {
+		return SummaryActivity{ID: TrackActivityID(start, 0), ExternalID: file, Type: "Ride", StartDate: start, Distance: km * 1000}
+	}
+
+	skipped := store.ImportTracks(commute("head unit.gpx", 10), commute("watch.gpx", 10.2), commute("daughter.gpx", 4))
+	if len(skipped) != 1 || skipped[0].ExternalID != "watch.gpx" {
+		t.Errorf("expected the watch's recording of the same ride to be skipped, got %+v", skipped)
+	}
+	// the file renamed, and the first file read again after it changed
+	skipped = store.ImportTracks(commute("renamed.gpx", 10), commute("head unit.gpx", 10.1))
+	if len(skipped) != 1 || skipped[0].ExternalID != "renamed.gpx" {
+		t.Errorf("expected the renamed file to be skipped, got %+v", skipped)
+	}
+
+	activities := store.Activities(time.Time{}, time.Time{})
+	if len(activities) != 2 || activities[0].ID == activities[1].ID || activities[0].ID >= 0 || activities[1].ID >= 0 {
+		t.Fatalf("expected the two different rides: %+v", activities)
+	}
+	for _, activity := range activities {
+		if activity.ExternalID == "head unit.gpx" && activity.Distance != 10100 {
+			t.Errorf("expected the changed file to replace the stored activity: %+v", activity)
+		}
+	}
+}
+
+// TestCommuteClassifier checks that activities between two of the places, or named as commutes,
+// are commutes.
+func TestCommuteClassifier(t *testing.T) {
+	classifier := CommuteClassifier{Places: []LatLng{{49.2800, -123.1200}, {49.2827, -123.1200}}, Radius: 50}
+	cases := []struct {
+		activity SummaryActivity
+		commute  bool
+	}{
+		{SummaryActivity{StartLatLng: []float64{49.2801, -123.1200}, EndLatLng: []float64{49.2827, -123.1201}}, true},
+		{SummaryActivity{StartLatLng: []float64{49.2801, -123.1200}, EndLatLng: []float64{49.2801, -123.1201}}, false},
+		{SummaryActivity{StartLatLng: []float64{49.3000, -123.1200}, EndLatLng: []float64{49.2827, -123.1201}}, false},
+		{SummaryActivity{Name: "Evening commute"}, true},
+	}
+	for _, c := range cases {
+		if classifier.IsCommute(c.activity) != c.commute {
+			t.Errorf("expected commute %v for %+v", c.commute, c.activity)
+		}
+	}
+}